@@ -0,0 +1,160 @@
+package api
+
+import (
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// AlertmanagerWebhook is the payload Prometheus Alertmanager posts to
+// webhook receivers
+type AlertmanagerWebhook struct {
+	Version           string              `json:"version"`
+	GroupKey          string              `json:"groupKey"`
+	TruncatedAlerts   int                 `json:"truncatedAlerts"`
+	Status            string              `json:"status"`
+	Receiver          string              `json:"receiver"`
+	GroupLabels       map[string]string   `json:"groupLabels"`
+	CommonLabels      map[string]string   `json:"commonLabels"`
+	CommonAnnotations map[string]string   `json:"commonAnnotations"`
+	ExternalURL       string              `json:"externalURL"`
+	Alerts            []AlertmanagerAlert `json:"alerts"`
+}
+
+type AlertmanagerAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// alertmanagerSeverities maps common severity label values onto incident
+// severities. Anything else is treated as low.
+var alertmanagerSeverities = map[string]incidents.Severity{
+	"critical": incidents.SeverityHigh,
+	"page":     incidents.SeverityHigh,
+	"high":     incidents.SeverityHigh,
+	"error":    incidents.SeverityHigh,
+	"major":    incidents.SeverityMedium,
+	"warning":  incidents.SeverityMedium,
+	"medium":   incidents.SeverityMedium,
+	"minor":    incidents.SeverityLow,
+	"low":      incidents.SeverityLow,
+	"info":     incidents.SeverityLow,
+}
+
+// ToEvent maps one alert of a group onto an ingestion event. Alerts are
+// deduplicated by fingerprint, falling back to the group key for older
+// Alertmanager versions that do not send one.
+func (a AlertmanagerAlert) ToEvent(webhook AlertmanagerWebhook) Event {
+	event := Event{
+		EventType: EventTrigger,
+		Severity:  incidents.SeverityLow,
+		Source:    a.Labels["instance"],
+		Details: map[string]interface{}{
+			"labels":       a.Labels,
+			"annotations":  a.Annotations,
+			"generatorURL": a.GeneratorURL,
+			"startsAt":     a.StartsAt,
+			"receiver":     webhook.Receiver,
+		},
+	}
+	if a.Status == "resolved" {
+		event.EventType = EventResolve
+	}
+
+	if a.Fingerprint != "" {
+		event.DedupKey = "alertmanager:" + a.Fingerprint
+	} else {
+		event.DedupKey = "alertmanager:" + webhook.GroupKey + ":" + a.Labels["alertname"]
+	}
+
+	if severity, ok := alertmanagerSeverities[strings.ToLower(a.Labels["severity"])]; ok {
+		event.Severity = severity
+	}
+	if event.Source == "" {
+		event.Source = a.Labels["job"]
+	}
+
+	event.Title = a.Annotations["summary"]
+	if event.Title == "" {
+		event.Title = a.Labels["alertname"]
+	}
+
+	var description []string
+	if text := a.Annotations["description"]; text != "" {
+		description = append(description, text)
+	}
+	for _, name := range []string{"alertname", "severity", "instance", "job"} {
+		if value := a.Labels[name]; value != "" {
+			description = append(description, fmt.Sprintf("%s: %s", name, value))
+		}
+	}
+	if a.GeneratorURL != "" {
+		description = append(description, "Link: "+a.GeneratorURL)
+	}
+	event.Description = strings.Join(description, "\n")
+
+	return event
+}
+
+// ReceiveAlertmanager ingests every alert of an Alertmanager webhook.
+// Firing alerts open or count on an incident, resolved alerts resolve it.
+func ReceiveAlertmanager(c *fiber.Ctx) error {
+	teamId := c.Locals("teamId").(string)
+
+	var team auth.Team
+	err := database.FindOne("teams", bson.M{"teamId": teamId}).Decode(&team)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Invalid API key"})
+	}
+
+	var webhook AlertmanagerWebhook
+	if err := c.BodyParser(&webhook); err != nil {
+		log.Println(err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "invalid Alertmanager payload",
+		})
+	}
+
+	var results []fiber.Map
+	failed := 0
+	integrationId, _ := c.Locals("integrationId").(string)
+	for _, alert := range webhook.Alerts {
+		event := alert.ToEvent(webhook)
+		event.Integration = integrationId
+		incident, outcome, err := Ingest(team, event)
+		result := fiber.Map{"fingerprint": alert.Fingerprint, "outcome": outcome}
+		if err != nil {
+			log.Println(err)
+			failed++
+			result["error"] = err.Error()
+		}
+		if incident != nil {
+			result["incident"] = incident.Id
+		}
+		results = append(results, result)
+	}
+
+	// a failure makes Alertmanager retry the whole group, which dedup keys
+	// make safe
+	status := 200
+	if failed > 0 {
+		status = fiber.StatusInternalServerError
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message": fmt.Sprintf("%d alerts received, %d failed", len(webhook.Alerts), failed),
+		"results": results,
+	})
+}
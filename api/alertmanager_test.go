@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"issue-reporting/incidents"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadAlertmanager(t *testing.T, name string) AlertmanagerWebhook {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var webhook AlertmanagerWebhook
+	if err := json.Unmarshal(data, &webhook); err != nil {
+		t.Fatal(err)
+	}
+	return webhook
+}
+
+func TestAlertmanagerToEvent(t *testing.T) {
+	firing := loadAlertmanager(t, "alertmanager_firing.json")
+	if len(firing.Alerts) != 2 {
+		t.Fatalf("%d alerts in fixture, want 2", len(firing.Alerts))
+	}
+
+	event := firing.Alerts[0].ToEvent(firing)
+	if event.EventType != EventTrigger {
+		t.Errorf("event type %q, want %q", event.EventType, EventTrigger)
+	}
+	if event.DedupKey != "alertmanager:5b3f2a9c6d1e8f07" {
+		t.Errorf("dedup key %q, want the fingerprint", event.DedupKey)
+	}
+	if event.Title != "High request latency on api-1" {
+		t.Errorf("title %q, want the summary annotation", event.Title)
+	}
+	if event.Source != "api-1.prod:9090" {
+		t.Errorf("source %q, want the instance label", event.Source)
+	}
+	if event.Severity != incidents.SeverityHigh {
+		t.Errorf("severity %q, want %q", event.Severity, incidents.SeverityHigh)
+	}
+	for _, want := range []string{"99th percentile latency", "alertname: HighRequestLatency", "Link: http://prometheus.prod:9090/graph"} {
+		if !strings.Contains(event.Description, want) {
+			t.Errorf("description %q is missing %q", event.Description, want)
+		}
+	}
+
+	// no summary or instance falls back to the alert name and job
+	event = firing.Alerts[1].ToEvent(firing)
+	if event.Title != "DiskWillFillIn4Hours" || event.Source != "node" {
+		t.Errorf("fallbacks: title %q, source %q", event.Title, event.Source)
+	}
+	if event.Severity != incidents.SeverityMedium {
+		t.Errorf("severity %q, want %q", event.Severity, incidents.SeverityMedium)
+	}
+
+	resolved := loadAlertmanager(t, "alertmanager_resolved.json")
+	event = resolved.Alerts[0].ToEvent(resolved)
+	if event.EventType != EventResolve {
+		t.Errorf("event type %q, want %q", event.EventType, EventResolve)
+	}
+	if event.DedupKey != firing.Alerts[0].ToEvent(firing).DedupKey {
+		t.Errorf("resolved dedup key %q does not match the firing one", event.DedupKey)
+	}
+}
+
+func TestAlertmanagerDedupKeyWithoutFingerprint(t *testing.T) {
+	webhook := loadAlertmanager(t, "alertmanager_firing.json")
+	alert := webhook.Alerts[0]
+	alert.Fingerprint = ""
+
+	event := alert.ToEvent(webhook)
+	if event.DedupKey != `alertmanager:{}:{job="api"}:HighRequestLatency` {
+		t.Errorf("dedup key %q, want the group key and alert name", event.DedupKey)
+	}
+}
+
+func TestAlertmanagerSeverities(t *testing.T) {
+	tests := map[string]incidents.Severity{
+		"critical": incidents.SeverityHigh,
+		"PAGE":     incidents.SeverityHigh,
+		"error":    incidents.SeverityHigh,
+		"major":    incidents.SeverityMedium,
+		"Warning":  incidents.SeverityMedium,
+		"info":     incidents.SeverityLow,
+		"":         incidents.SeverityLow,
+		"unknown":  incidents.SeverityLow,
+	}
+	for label, want := range tests {
+		alert := AlertmanagerAlert{Status: "firing", Labels: map[string]string{"alertname": "Test", "severity": label}}
+		if got := alert.ToEvent(AlertmanagerWebhook{}).Severity; got != want {
+			t.Errorf("severity label %q = %q, want %q", label, got, want)
+		}
+	}
+}
+
+func TestAlertmanagerResolvePath(t *testing.T) {
+	team := testTeam(t)
+	firing := loadAlertmanager(t, "alertmanager_firing.json")
+	resolved := loadAlertmanager(t, "alertmanager_resolved.json")
+
+	created, outcome, err := Ingest(team, firing.Alerts[0].ToEvent(firing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeCreated {
+		t.Fatalf("firing: outcome %s, want %s", outcome, OutcomeCreated)
+	}
+
+	incident, outcome, err := Ingest(team, resolved.Alerts[0].ToEvent(resolved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeResolved || incident.Id != created.Id || !incident.Resolved {
+		t.Errorf("resolved: outcome %s, incident %s, resolved %v", outcome, incident.Id, incident.Resolved)
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/notification"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AlertState follows the triggered/resolved state of one dedup key. An
+// alert flaps once it changed state more than FLAP_THRESHOLD times within
+// FLAP_WINDOW_MINUTES, and stays flapping until it has been quiet for a
+// whole window.
+type AlertState struct {
+	TeamId         string      `json:"teamId"`
+	DedupKey       string      `json:"dedup_key"`
+	State          EventType   `json:"state"`
+	Transitions    []time.Time `json:"transitions"`
+	Flapping       bool        `json:"flapping"`
+	LastTransition time.Time   `json:"last_transition"`
+}
+
+func flapSettings() (int, time.Duration) {
+	threshold, window := 4, 30
+	if value, err := strconv.Atoi(os.Getenv("FLAP_THRESHOLD")); err == nil && value > 0 {
+		threshold = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("FLAP_WINDOW_MINUTES")); err == nil && value > 0 {
+		window = value
+	}
+	return threshold, time.Duration(window) * time.Minute
+}
+
+// recordTransition notes the state an event puts its alert in and reports
+// whether the alert is flapping
+func recordTransition(teamId string, event Event, now time.Time) (bool, error) {
+	state := EventTrigger
+	if event.EventType == EventResolve {
+		state = EventResolve
+	}
+
+	var current AlertState
+	err := database.FindOne("alertstates", bson.M{"teamid": teamId, "dedupkey": event.DedupKey}).Decode(&current)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return false, err
+	}
+	if err == nil && current.State == state {
+		return current.Flapping, nil
+	}
+
+	threshold, window := flapSettings()
+	transitions := []time.Time{}
+	for _, at := range current.Transitions {
+		if now.Sub(at) < window {
+			transitions = append(transitions, at)
+		}
+	}
+	transitions = append(transitions, now)
+	flapping := current.Flapping || len(transitions) > threshold
+
+	update := bson.M{"$set": bson.M{
+		"state":          state,
+		"transitions":    transitions,
+		"flapping":       flapping,
+		"lasttransition": now,
+	}}
+	opts := options.Update().SetUpsert(true)
+	_, err = database.GetDatabase().Database("IssueReporting").Collection("alertstates").UpdateOne(context.Background(), bson.M{"teamid": teamId, "dedupkey": event.DedupKey}, update, opts)
+	return flapping, err
+}
+
+// holdFlapping marks an incident as flapping, which keeps it open through
+// resolve events, and tells its assignees once instead of paging them on
+// every cycle
+func holdFlapping(team auth.Team, incident incidents.Incident) (*incidents.Incident, error) {
+	if incident.Flapping {
+		return &incident, nil
+	}
+
+	timepoint, subtext := flappingTimepoint(team)
+	update := bson.M{"$set": bson.M{"flapping": true, "updatedat": time.Now()}, "$push": bson.M{"timeline": timepoint}}
+
+	var updated incidents.Incident
+	err := database.FindOneAndUpdate("incidents", bson.M{"id": incident.Id}, update).Decode(&updated)
+	if err != nil {
+		return nil, err
+	}
+
+	if !updated.Suppressed {
+		pageFlapping(updated, subtext)
+	}
+	return &updated, nil
+}
+
+// flappingTimepoint notes on the timeline that the incident is held open
+func flappingTimepoint(team auth.Team) (incidents.Timepoint, string) {
+	threshold, window := flapSettings()
+	subtext := fmt.Sprintf("Alert changed state more than %d times in %d minutes. Holding the incident open until it settles.", threshold, int(window.Minutes()))
+	data := map[string]interface{}{
+		"createdby": team.TeamName,
+		"subtext":   subtext,
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+	}
+
+	return incidents.Timepoint{
+		Title:     "Flapping 🔃",
+		CreatedAt: time.Now(),
+		Metadata:  string(jsonData),
+	}, subtext
+}
+
+// pageFlapping sends the one page assignees get for a flapping alert
+func pageFlapping(incident incidents.Incident, subtext string) {
+	for _, user := range incident.AssignedTo {
+		notification.SendPage(fmt.Sprintf("Incident #%s is flapping\nTitle: %s\n%s\nYou will not be paged again for it until it settles.", incident.Id, incident.Title, subtext), user, incident.Id)
+	}
+}
+
+// SettleFlapping ends flapping for alerts that have been quiet for a whole
+// window. Incidents held open for an alert that ended resolved are resolved
+// now, the others carry on as normal incidents.
+func SettleFlapping(now time.Time) {
+	ctx := context.Background()
+	_, window := flapSettings()
+	cursor, err := database.Find("alertstates", bson.M{"flapping": true, "lasttransition": bson.M{"$lt": now.Add(-window)}})
+	if err != nil {
+		log.Printf("Error listing flapping alerts: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var states []AlertState
+	if err := cursor.All(ctx, &states); err != nil {
+		log.Printf("Error decoding flapping alerts: %v", err)
+		return
+	}
+
+	for _, state := range states {
+		if err := settle(state); err != nil {
+			log.Printf("Error settling flapping alert %s: %v", state.DedupKey, err)
+		}
+	}
+}
+
+func settle(state AlertState) error {
+	filter := bson.M{"teamid": state.TeamId, "dedupkey": state.DedupKey}
+	if _, err := database.UpdateOne("alertstates", filter, bson.M{"$set": bson.M{"flapping": false, "transitions": []time.Time{}}}); err != nil {
+		return err
+	}
+
+	var incident incidents.Incident
+	err := database.FindOne("incidents", bson.M{"teamid": state.TeamId, "dedupkey": state.DedupKey, "resolved": false, "flapping": true}).Decode(&incident)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var team auth.Team
+	if err := database.FindOne("teams", bson.M{"teamId": state.TeamId}).Decode(&team); err != nil {
+		return err
+	}
+
+	subtext := "Alert settled and is still firing"
+	if state.State == EventResolve {
+		subtext = "Alert settled in the resolved state"
+	}
+	data := map[string]interface{}{
+		"createdby": team.TeamName,
+		"subtext":   subtext,
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+	}
+
+	timepoint := incidents.Timepoint{
+		Title:     "Settled",
+		CreatedAt: time.Now(),
+		Metadata:  string(jsonData),
+	}
+	update := bson.M{"$set": bson.M{"flapping": false, "updatedat": time.Now()}, "$push": bson.M{"timeline": timepoint}}
+	err = database.FindOneAndUpdate("incidents", bson.M{"id": incident.Id}, update).Decode(&incident)
+	if err != nil {
+		return err
+	}
+
+	if state.State == EventResolve {
+		_, err = resolveDuplicate(team, incident)
+	}
+	return err
+}
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GroupingConfig makes alerts of a team that agree on Fields and arrive
+// within Window minutes of an open incident join it as child alerts
+// instead of opening incidents of their own. Fields are source, title,
+// title_prefix (the first PrefixLength characters of the title),
+// severity, integration or details.<name>.
+type GroupingConfig struct {
+	TeamId       string    `json:"teamId"`
+	Enabled      bool      `json:"enabled"`
+	Window       int       `json:"window"`
+	Fields       []string  `json:"fields"`
+	PrefixLength int       `json:"prefixLength"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+const defaultPrefixLength = 20
+
+// groupingFor returns the team's grouping config, nil when it has none or
+// grouping is off
+func groupingFor(teamId string) (*GroupingConfig, error) {
+	var config GroupingConfig
+	err := database.FindOne("groupingconfigs", bson.M{"teamid": teamId}).Decode(&config)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !config.Enabled {
+		return nil, nil
+	}
+	return &config, nil
+}
+
+// groupKey identifies the group an event belongs to. It is empty when the
+// event has none of the grouping fields, such events are not grouped.
+func (g GroupingConfig) groupKey(event Event) string {
+	var parts []string
+	found := false
+	for _, field := range g.Fields {
+		var value string
+		switch field {
+		case "source":
+			value = event.Source
+		case "title":
+			value = event.Title
+		case "title_prefix":
+			length := g.PrefixLength
+			if length <= 0 {
+				length = defaultPrefixLength
+			}
+			runes := []rune(event.Title)
+			if len(runes) > length {
+				runes = runes[:length]
+			}
+			value = string(runes)
+		case "severity":
+			value = string(event.Severity)
+		case "integration":
+			value = event.Integration
+		default:
+			name := strings.TrimPrefix(field, "details.")
+			if detail, ok := event.Details[name]; ok && detail != nil {
+				data, _ := json.Marshal(detail)
+				value = string(data)
+			}
+		}
+		if value != "" {
+			found = true
+		}
+		parts = append(parts, field+"="+strings.ToLower(value))
+	}
+	if !found {
+		return ""
+	}
+
+	sum := sha1.Sum([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func alertFrom(event Event) incidents.Alert {
+	return incidents.Alert{
+		Title:       event.Title,
+		Description: event.Description,
+		Severity:    event.Severity,
+		Source:      event.Source,
+		DedupKey:    event.DedupKey,
+		Details:     event.Details,
+		ReceivedAt:  time.Now(),
+	}
+}
+
+// groupInto attaches an event to the open incident of its group within the
+// window, if there is one
+func groupInto(team auth.Team, config GroupingConfig, key string, event Event) (*incidents.Incident, error) {
+	filter := bson.M{
+		"teamid":    team.TeamId,
+		"groupkey":  key,
+		"resolved":  false,
+		"createdat": bson.M{"$gte": time.Now().Add(-time.Duration(config.Window) * time.Minute)},
+	}
+
+	data := map[string]interface{}{
+		"createdby": team.TeamName,
+		"subtext":   fmt.Sprintf("Related alert grouped into this incident: %s", event.Title),
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+	}
+
+	timepoint := incidents.Timepoint{
+		Title:     "Alert Grouped 🧩",
+		CreatedAt: time.Now(),
+		Metadata:  string(jsonData),
+	}
+	update := bson.M{
+		"$push": bson.M{"alerts": alertFrom(event), "timeline": timepoint},
+		"$inc":  bson.M{"alertcount": 1},
+		"$set":  bson.M{"updatedat": time.Now()},
+	}
+
+	var incident incidents.Incident
+	err = database.FindOneAndUpdate("incidents", filter, update).Decode(&incident)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &incident, nil
+}
+
+// resolveGroupedAlert resolves the alerts of a grouped incident that carry
+// the dedup key, and the incident once none of its keyed alerts is left open
+func resolveGroupedAlert(team auth.Team, incident incidents.Incident, dedupKey string) (*incidents.Incident, error) {
+	// flag the alert in place so resolves of other alerts arriving at the
+	// same time are not overwritten
+	opts := options.FindOneAndUpdate().
+		SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"a.dedupkey": dedupKey}}}).
+		SetReturnDocument(options.After)
+	update := bson.M{"$set": bson.M{"alerts.$[a].resolved": true, "updatedat": time.Now()}}
+
+	var updated incidents.Incident
+	err := database.GetDatabase().Database("IssueReporting").Collection("incidents").FindOneAndUpdate(context.Background(), bson.M{"id": incident.Id}, update, opts).Decode(&updated)
+	if err != nil {
+		return nil, err
+	}
+
+	// alerts without a dedup key can never be resolved by an event, so
+	// they do not hold the incident open
+	open := 0
+	for _, alert := range updated.Alerts {
+		if alert.DedupKey != "" && !alert.Resolved {
+			open++
+		}
+	}
+	if open == 0 {
+		return resolveDuplicate(team, updated)
+	}
+
+	data := map[string]interface{}{
+		"createdby": team.TeamName,
+		"subtext":   fmt.Sprintf("Grouped alert %s resolved, %d still open", dedupKey, open),
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+	}
+
+	timepoint := incidents.Timepoint{
+		Title:     "Alert Resolved",
+		CreatedAt: time.Now(),
+		Metadata:  string(jsonData),
+	}
+
+	err = database.FindOneAndUpdate("incidents", bson.M{"id": incident.Id}, bson.M{"$push": bson.M{"timeline": timepoint}}).Decode(&updated)
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func GetGrouping(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	config := GroupingConfig{TeamId: user.TeamId, Fields: []string{}}
+	err = database.FindOne("groupingconfigs", bson.M{"teamid": user.TeamId}).Decode(&config)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":  "grouping data",
+		"grouping": config,
+	})
+}
+
+func UpdateGrouping(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var config GroupingConfig
+	if err := c.BodyParser(&config); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if err := VerifyGrouping(config); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	config.TeamId = user.TeamId
+	config.UpdatedAt = time.Now()
+
+	opts := options.Update().SetUpsert(true)
+	_, err = database.GetDatabase().Database("IssueReporting").Collection("groupingconfigs").UpdateOne(context.Background(), bson.M{"teamid": user.TeamId}, bson.M{"$set": config}, opts)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "grouping updated",
+		"grouping": config,
+	})
+}
+
+func VerifyGrouping(config GroupingConfig) error {
+	if !config.Enabled {
+		return nil
+	}
+	if config.Window <= 0 {
+		return errors.New("window must be greater than zero")
+	}
+	if len(config.Fields) == 0 {
+		return errors.New("at least one field is required")
+	}
+	for _, field := range config.Fields {
+		switch field {
+		case "source", "title", "title_prefix", "severity", "integration":
+		default:
+			if !strings.HasPrefix(field, "details.") || field == "details." {
+				return fmt.Errorf("unknown grouping field %q", field)
+			}
+		}
+	}
+	if config.PrefixLength < 0 {
+		return errors.New("prefixLength cannot be negative")
+	}
+	return nil
+}
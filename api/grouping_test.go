@@ -0,0 +1,135 @@
+package api
+
+import (
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestResolveGroupedAlerts(t *testing.T) {
+	team := testTeam(t)
+	config := GroupingConfig{TeamId: team.TeamId, Enabled: true, Window: 30, Fields: []string{"source"}, UpdatedAt: time.Now()}
+	if _, err := database.InsertOne("groupingconfigs", config); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"db-1-cpu", "db-1-disk", "db-1-memory"}
+	var incidentId string
+	for i, key := range keys {
+		incident, outcome, err := Ingest(team, Event{Title: key, Source: "db-1", EventType: EventTrigger, DedupKey: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			incidentId = incident.Id
+		} else if outcome != OutcomeGrouped || incident.Id != incidentId {
+			t.Fatalf("%s: outcome %s, incident %s", key, outcome, incident.Id)
+		}
+	}
+
+	incident, outcome, err := Ingest(team, Event{EventType: EventResolve, DedupKey: keys[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeResolved || incident.Resolved {
+		t.Fatalf("first resolve: outcome %s, incident resolved %v", outcome, incident.Resolved)
+	}
+
+	// the last two resolves race each other, neither may undo the other
+	var wg sync.WaitGroup
+	for _, key := range keys[1:] {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			if _, _, err := Ingest(team, Event{EventType: EventResolve, DedupKey: key}); err != nil {
+				t.Error(err)
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	var stored struct {
+		Resolved bool
+		Alerts   []struct{ Resolved bool }
+	}
+	if err := database.FindOne("incidents", bson.M{"id": incidentId}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	for i, alert := range stored.Alerts {
+		if !alert.Resolved {
+			t.Errorf("alert %d is still open", i)
+		}
+	}
+	if !stored.Resolved {
+		t.Error("incident is still open with every alert resolved")
+	}
+}
+
+func groupBySource(t *testing.T) auth.Team {
+	t.Helper()
+	team := testTeam(t)
+	config := GroupingConfig{TeamId: team.TeamId, Enabled: true, Window: 30, Fields: []string{"source"}, UpdatedAt: time.Now()}
+	if _, err := database.InsertOne("groupingconfigs", config); err != nil {
+		t.Fatal(err)
+	}
+	// keep flap detection out of the way of the re-triggers below
+	t.Setenv("FLAP_THRESHOLD", "100")
+	return team
+}
+
+func ingest(t *testing.T, team auth.Team, event Event) (*incidents.Incident, Outcome) {
+	t.Helper()
+	incident, outcome, err := Ingest(team, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return incident, outcome
+}
+
+func TestResolveGroupedAlertsWithoutDedupKey(t *testing.T) {
+	team := groupBySource(t)
+
+	created, _ := ingest(t, team, Event{Title: "CPU high", Source: "db-2", EventType: EventTrigger, DedupKey: "db-2-cpu"})
+	// nothing can ever resolve this one by key
+	grouped, outcome := ingest(t, team, Event{Title: "Slow queries", Source: "db-2", EventType: EventTrigger})
+	if outcome != OutcomeGrouped || grouped.Id != created.Id {
+		t.Fatalf("unkeyed alert: outcome %s, incident %s", outcome, grouped.Id)
+	}
+
+	resolved, _ := ingest(t, team, Event{EventType: EventResolve, DedupKey: "db-2-cpu"})
+	if !resolved.Resolved {
+		t.Error("incident left open by an alert without a dedup key")
+	}
+}
+
+func TestResolveGroupedAlertAfterRetrigger(t *testing.T) {
+	team := groupBySource(t)
+
+	created, _ := ingest(t, team, Event{Title: "CPU high", Source: "db-3", EventType: EventTrigger, DedupKey: "db-3-cpu"})
+	ingest(t, team, Event{Title: "Disk full", Source: "db-3", EventType: EventTrigger, DedupKey: "db-3-disk"})
+
+	ingest(t, team, Event{EventType: EventResolve, DedupKey: "db-3-cpu"})
+	retriggered, outcome := ingest(t, team, Event{Title: "CPU high", Source: "db-3", EventType: EventTrigger, DedupKey: "db-3-cpu"})
+	if outcome != OutcomeDeduplicated || retriggered.Id != created.Id {
+		t.Fatalf("re-trigger: outcome %s, incident %s", outcome, retriggered.Id)
+	}
+	for _, alert := range retriggered.Alerts {
+		if alert.DedupKey == "db-3-cpu" && alert.Resolved {
+			t.Error("re-triggered alert is still marked resolved")
+		}
+	}
+
+	incident, _ := ingest(t, team, Event{EventType: EventResolve, DedupKey: "db-3-disk"})
+	if incident.Resolved {
+		t.Fatal("incident resolved while a re-triggered alert is still firing")
+	}
+	incident, _ = ingest(t, team, Event{EventType: EventResolve, DedupKey: "db-3-cpu"})
+	if !incident.Resolved {
+		t.Error("incident still open with every alert resolved")
+	}
+}
@@ -1,17 +1,15 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"issue-reporting/auth"
 	"issue-reporting/database"
 	"issue-reporting/incidents"
-	"issue-reporting/notification"
-	"issue-reporting/schedules"
-	"issue-reporting/slack"
 	"issue-reporting/utils"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -27,156 +25,40 @@ func CreateIncident(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Invalid API key"})
 	}
 
-	// initiate an incident template
-	incident := incidents.Incident{
-		Severity:     "Low",
-		Status:       "Open",
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
-		Acknowledged: false,
-		Resolved:     false,
-		Timeline:     []incidents.Timepoint{},
-		AssignedTo:   []auth.User{},
-	}
-
-	if err := c.BodyParser(&incident); err != nil {
+	var event Event
+	if err := c.BodyParser(&event); err != nil {
 		// Handle parsing error
 		log.Println(err)
 		return err
 	}
 
-	// create a timeline item for when incident is created
-	data := map[string]interface{}{
-		"createdby": team.TeamName,
-		"subtext":   fmt.Sprintf("Initiated by %s", team.TeamName),
-	}
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		fmt.Println("Error marshalling JSON:", err)
-	}
-	jsonString := string(jsonData)
+	event.Integration, _ = c.Locals("integrationId").(string)
 
-	// start populating the incident with main details
-	code, err := utils.GenerateRandomCode(6)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	incident.Id = code
-	incident.Metadata = jsonString
-	incident.TeamId = team.TeamId
-	incident.Resolved = false
-	incident.Timeline = append(incident.Timeline, incidents.Timepoint{
-		Title:     "Incident Created",
-		CreatedAt: time.Now(),
-		Metadata:  jsonString,
-	})
-	var text string
-	var severity string
-	switch incident.Severity {
-	case "Low":
-		severity = "Low 🟨"
-	case "Medium":
-		severity = "Medium 🟥"
-	case "High":
-		severity = "High 🆘"
-	default:
-		severity = "Unknown"
-	}
-
-	// check who is on-call
-	schedule, err := schedules.Scheduled(time.Now(), team.TeamId)
-	if err != nil {
-		log.Println(err)
-		return c.Status(fiber.StatusExpectationFailed).JSON(fiber.Map{
-			"message": "can not get on-call engineer",
-			"status":  false,
+	if err := VerifyEvent(event); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
 		})
 	}
 
-	var scheduledUser auth.User
-	if schedule != nil {
-		err := database.FindOne("users", bson.M{"email": schedule.User.Email}).Decode(&scheduledUser)
-		if err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"message": "Invalid credentials",
-				"status":  false,
-			})
-		}
-		incident.AssignedTo = append(incident.AssignedTo, scheduledUser)
-	}
-
-	if len(incident.AssignedTo) > 0 {
-		assignedToNames := make([]string, len(incident.AssignedTo))
-		for i, user := range incident.AssignedTo {
-			assignedToNames[i] = fmt.Sprintf("%s <%s>", user.Name, user.GithubHandle)
-		}
-		assignedToList := strings.Join(assignedToNames, ", ")
-		data := map[string]interface{}{
-			"assignedTo": assignedToList,
-			"subtext":    fmt.Sprintf("Assigned to: %s", assignedToList),
-		}
-
-		jsonData, err := json.Marshal(data)
-		if err != nil {
-			fmt.Println("Error marshalling JSON:", err)
-		}
-
-		jsonString := string(jsonData)
-		incident.Metadata = jsonString
-		incident.Timeline = append(incident.Timeline, incidents.Timepoint{
-			Title:     "Incident Assigned",
-			CreatedAt: time.Now(),
-			Metadata:  jsonString,
-		})
-		text = fmt.Sprintf("Incident #%s created and assigned to %s\n\n%s\n%s\nSeverity: %s", incident.Id, assignedToList, incident.Title, incident.Description, severity)
-	} else {
-		text = fmt.Sprintf("Incident #%s created and unassigned\n\n%s\n%s\nSeverity: %s", incident.Id, incident.Title, incident.Description, severity)
-	}
-	err = slack.Notify(&slack.NotifyParams{Text: text})
-	if err != nil {
-		log.Println(err)
-	}
-
-	data = map[string]interface{}{
-		"createdby": team.TeamName,
-		"subtext":   "Alert sent to everyone on-call and slack",
-	}
-
-	jsonData, err = json.Marshal(data)
-	if err != nil {
-		fmt.Println("Error marshalling JSON:", err)
-	}
-
-	jsonString = string(jsonData)
-
-	incident.Timeline = append(incident.Timeline, incidents.Timepoint{
-		Title:     "Alerted",
-		CreatedAt: time.Now(),
-		Metadata:  jsonString,
-	})
-
-	// Someone is on-call
-	_, err = database.InsertOne("incidents", incident)
+	incident, outcome, err := Ingest(team, event)
 	if err != nil {
 		log.Println(err)
 		return c.Status(fiber.StatusExpectationFailed).JSON(fiber.Map{
-			"message": "incident not created",
+			"message": err.Error(),
 			"status":  false,
 		})
 	}
 
-	if len(incident.AssignedTo) > 0 {
-		for _, user := range incident.AssignedTo {
-			// assignedToNames[i] = fmt.Sprintf("%s <%s>", user.Name, user.GithubHandle)
-			notification.SendNotification(fmt.Sprintf("You have been assigned to: \nIncident #%s\nTitle: %s\nDescription: %s\nSeverity: %s", incident.Id, incident.Title, incident.Description, incident.Severity), user)
-		}
+	response := fiber.Map{
+		"message": "incident " + string(outcome),
+		"outcome": outcome,
 	}
-
-	return c.Status(200).JSON(fiber.Map{
-		"message":  "incident created",
-		"incident": incident.Id,
-	})
+	if incident != nil {
+		response["incident"] = incident.Id
+		response["alert_count"] = incident.AlertCount
+	}
+	return c.Status(200).JSON(response)
 }
 
 func CreateLog(c *fiber.Ctx) error {
@@ -205,10 +87,8 @@ func CreateLog(c *fiber.Ctx) error {
 		return err
 	}
 	logx.Id = code
-	logx.TeamId = team.TeamId
 
-	// Someone is on-call
-	_, err = database.InsertOne("logs", logx)
+	err = StoreLogs(team, []incidents.Log{logx})
 	if err != nil {
 		log.Println(err)
 		return c.Status(fiber.StatusExpectationFailed).JSON(fiber.Map{
@@ -222,3 +102,93 @@ func CreateLog(c *fiber.Ctx) error {
 		"log":     logx.Id,
 	})
 }
+
+// maxLogBatch caps how many logs one batch request may carry
+const maxLogBatch = 10000
+
+// CreateLogs stores a batch of logs sent as a JSON array or as NDJSON, one
+// log per line. The batch is rejected as a whole if any log is malformed.
+func CreateLogs(c *fiber.Ctx) error {
+	teamId := c.Locals("teamId").(string)
+
+	var team auth.Team
+	err := database.FindOne("teams", bson.M{"teamId": teamId}).Decode(&team)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Invalid API key"})
+	}
+
+	logs, err := decodeLogs(c.Body())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+	if len(logs) > maxLogBatch {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error":   "Request Entity Too Large",
+			"message": fmt.Sprintf("at most %d logs can be sent at once", maxLogBatch),
+		})
+	}
+
+	now := time.Now()
+	for i := range logs {
+		code, err := utils.GenerateRandomCode(6)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		logs[i].Id = code
+		if logs[i].CreatedAt.IsZero() {
+			logs[i].CreatedAt = now
+		}
+	}
+
+	err = StoreLogs(team, logs)
+	if err != nil {
+		log.Println(err)
+		return c.Status(fiber.StatusExpectationFailed).JSON(fiber.Map{
+			"message": "logs not created",
+			"status":  false,
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "logs created",
+		"count":   len(logs),
+	})
+}
+
+// decodeLogs reads a JSON array of logs or a stream of newline delimited
+// ones
+func decodeLogs(body []byte) ([]incidents.Log, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, fmt.Errorf("no logs sent")
+	}
+
+	var logs []incidents.Log
+	if body[0] == '[' {
+		if err := json.Unmarshal(body, &logs); err != nil {
+			return nil, err
+		}
+		return logs, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for line := 1; ; line++ {
+		var logx incidents.Log
+		err := decoder.Decode(&logx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("log %d: %v", line, err)
+		}
+		logs = append(logs, logx)
+		if len(logs) > maxLogBatch {
+			break
+		}
+	}
+	return logs, nil
+}
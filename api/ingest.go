@@ -0,0 +1,639 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/maintenance"
+	"issue-reporting/notification"
+	"issue-reporting/rules"
+	"issue-reporting/schedules"
+	"issue-reporting/slack"
+	"issue-reporting/utils"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errDuplicateAlert means another trigger with the same dedup key opened
+// the incident first
+var errDuplicateAlert = errors.New("incident with this dedup key already open")
+
+// EnsureDedupIndex lets the database refuse a second open incident with
+// the same dedup key, so triggers racing each other cannot both create one.
+func EnsureDedupIndex() error {
+	index := mongo.IndexModel{
+		Keys: bson.D{{Key: "teamid", Value: 1}, {Key: "dedupkey", Value: 1}},
+		Options: options.Index().
+			SetName("open_dedupkey").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"resolved": false, "dedupkey": bson.M{"$gt": ""}}),
+	}
+	_, err := database.GetDatabase().Database("IssueReporting").Collection("incidents").Indexes().CreateOne(context.Background(), index)
+	return err
+}
+
+func VerifyEvent(event Event) error {
+	switch event.EventType {
+	case "", EventTrigger:
+	case EventAcknowledge, EventResolve:
+		if event.DedupKey == "" {
+			return fmt.Errorf("%s events need a dedup_key", event.EventType)
+		}
+	default:
+		return fmt.Errorf("unknown event_type %q", event.EventType)
+	}
+	return nil
+}
+
+// Ingest applies an event for the team. A trigger opens a new incident
+// unless an unresolved one with the same dedup key exists, in which case the
+// alert is counted on it. Acknowledge and resolve events act on the
+// incident with that key. The team's event rules see every trigger first
+// and may change or drop it, and teams that group alerts have related
+// triggers join an open incident of their group. Alerts that flap are held
+// open through resolves until they settle, and alerts still firing after
+// the maintenance window that suppressed them page the on-call responder.
+func Ingest(team auth.Team, event Event) (*incidents.Incident, Outcome, error) {
+	var result *rules.Result
+	if event.EventType == "" || event.EventType == EventTrigger {
+		var err error
+		result, err = rules.Evaluate(team.TeamId, rules.Alert{
+			Title:       event.Title,
+			Description: event.Description,
+			Severity:    event.Severity,
+			Source:      event.Source,
+			Details:     event.Details,
+		})
+		if err != nil {
+			// a broken rule must not lose the alert
+			log.Printf("Error evaluating event rules for team %s: %v", team.TeamId, err)
+		}
+		if result != nil && result.Actions.Drop {
+			return nil, OutcomeDropped, nil
+		}
+		if result != nil && result.Actions.Severity != "" {
+			event.Severity = result.Actions.Severity
+		}
+	}
+
+	// alerts that keep triggering and resolving are held open instead
+	flapping := false
+	if event.DedupKey != "" && event.EventType != EventAcknowledge {
+		var err error
+		flapping, err = recordTransition(team.TeamId, event, time.Now())
+		if err != nil {
+			log.Printf("Error recording state of alert %s: %v", event.DedupKey, err)
+		}
+	}
+
+	if event.DedupKey != "" {
+		var existing incidents.Incident
+		// grouped alerts keep their key on the incident they joined
+		filter := bson.M{"teamid": team.TeamId, "resolved": false, "$or": []bson.M{
+			{"dedupkey": event.DedupKey},
+			{"alerts.dedupkey": event.DedupKey},
+		}}
+		err := database.FindOne("incidents", filter).Decode(&existing)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, "", err
+		}
+		if err == nil {
+			switch event.EventType {
+			case EventResolve:
+				if flapping {
+					incident, err := holdFlapping(team, existing)
+					return incident, OutcomeHeld, err
+				}
+				if len(existing.Alerts) > 1 {
+					incident, err := resolveGroupedAlert(team, existing, event.DedupKey)
+					return incident, OutcomeResolved, err
+				}
+				incident, err := resolveDuplicate(team, existing)
+				return incident, OutcomeResolved, err
+			case EventAcknowledge:
+				incident, err := acknowledgeDuplicate(team, existing)
+				return incident, OutcomeAcknowledged, err
+			}
+			incident, err := countDuplicate(team, existing, event)
+			if err == nil && incident.Suppressed && incident.Maintenance != "" && (result == nil || !result.Actions.Suppress) {
+				incident, err = releaseAfterMaintenance(team, *incident)
+			}
+			if err == nil && flapping {
+				incident, err = holdFlapping(team, *incident)
+			}
+			return incident, OutcomeDeduplicated, err
+		}
+	}
+
+	// nothing open to act on
+	if event.EventType == EventResolve || event.EventType == EventAcknowledge {
+		return nil, OutcomeIgnored, nil
+	}
+
+	grouping, err := groupingFor(team.TeamId)
+	if err != nil {
+		log.Printf("Error finding grouping config for team %s: %v", team.TeamId, err)
+	}
+	var groupKey string
+	if grouping != nil {
+		groupKey = grouping.groupKey(event)
+	}
+	if groupKey != "" {
+		grouped, err := groupInto(team, *grouping, groupKey, event)
+		if err != nil {
+			return nil, "", err
+		}
+		if grouped != nil {
+			return grouped, OutcomeGrouped, nil
+		}
+	}
+
+	incident := incidents.Incident{
+		Title:       event.Title,
+		Description: event.Description,
+		Severity:    event.Severity,
+		DedupKey:    event.DedupKey,
+		Source:      event.Source,
+		Details:     event.Details,
+		Integration: event.Integration,
+	}
+	if incident.Severity == "" {
+		incident.Severity = incidents.SeverityLow
+	}
+	incident.Flapping = flapping
+	if groupKey != "" {
+		incident.GroupKey = groupKey
+		incident.Alerts = []incidents.Alert{alertFrom(event)}
+	}
+	if result != nil {
+		incident.EscalationPolicy = result.Actions.EscalationPolicy
+		incident.Tags = result.Actions.Tags
+	}
+	created, err := createIncident(team, incident, result)
+	if err == errDuplicateAlert {
+		// lost the race, count it on the incident that won instead
+		var existing incidents.Incident
+		err := database.FindOne("incidents", bson.M{"teamid": team.TeamId, "dedupkey": event.DedupKey, "resolved": false}).Decode(&existing)
+		if err != nil {
+			return nil, "", err
+		}
+		incident, err := countDuplicate(team, existing, event)
+		return incident, OutcomeDeduplicated, err
+	}
+	return created, OutcomeCreated, err
+}
+
+// countDuplicate records a repeated alert on the open incident it belongs to
+func countDuplicate(team auth.Team, incident incidents.Incident, event Event) (*incidents.Incident, error) {
+	// incidents created before dedup keys existed have no count yet
+	count := incident.AlertCount + 1
+	if incident.AlertCount == 0 {
+		count = 2
+	}
+
+	data := map[string]interface{}{
+		"createdby": team.TeamName,
+		"subtext":   fmt.Sprintf("Alert received again (%d times): %s", count, event.Title),
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+	}
+
+	timepoint := incidents.Timepoint{
+		Title:     "Alert Received 🔁",
+		CreatedAt: time.Now(),
+		Metadata:  string(jsonData),
+	}
+
+	// incremented in place so duplicates arriving together are all counted
+	filter := bson.M{"id": incident.Id}
+	update := bson.M{"$inc": bson.M{"alertcount": 1}, "$set": bson.M{"updatedat": time.Now()}, "$push": bson.M{"timeline": timepoint}}
+	if incident.AlertCount == 0 {
+		update = bson.M{"$set": bson.M{"alertcount": count, "updatedat": time.Now()}, "$push": bson.M{"timeline": timepoint}}
+	}
+
+	if len(incident.Alerts) > 0 && event.DedupKey != "" {
+		// a grouped alert firing again is open again
+		update["$set"].(bson.M)["alerts.$[a].resolved"] = false
+		opts := options.FindOneAndUpdate().
+			SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"a.dedupkey": event.DedupKey}}}).
+			SetReturnDocument(options.After)
+
+		var updated incidents.Incident
+		err = database.GetDatabase().Database("IssueReporting").Collection("incidents").FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&updated)
+		if err != nil {
+			return nil, err
+		}
+		return &updated, nil
+	}
+
+	var updated incidents.Incident
+	err = database.FindOneAndUpdate("incidents", filter, update).Decode(&updated)
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func resolveDuplicate(team auth.Team, incident incidents.Incident) (*incidents.Incident, error) {
+	data := map[string]interface{}{
+		"resolvedBy": team.TeamName,
+		"subtext":    fmt.Sprintf("Incident has been resolved by %s", team.TeamName),
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+	}
+
+	timepoint := incidents.Timepoint{
+		Title:     "Resolved ✅",
+		CreatedAt: time.Now(),
+		Metadata:  string(jsonData),
+	}
+
+	filter := bson.M{"id": incident.Id}
+	update := bson.M{"$set": bson.M{"resolved": true, "resolvedat": time.Now()}, "$push": bson.M{"timeline": timepoint}}
+
+	var updated incidents.Incident
+	err = database.FindOneAndUpdate("incidents", filter, update).Decode(&updated)
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func acknowledgeDuplicate(team auth.Team, incident incidents.Incident) (*incidents.Incident, error) {
+	if incident.Acknowledged {
+		return &incident, nil
+	}
+
+	data := map[string]interface{}{
+		"acknowledBy": team.TeamName,
+		"subtext":     fmt.Sprintf("Incident has been acknowledged by %s", team.TeamName),
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+	}
+
+	timepoint := incidents.Timepoint{
+		Title:     "Acknowledged 👍🏼",
+		CreatedAt: time.Now(),
+		Metadata:  string(jsonData),
+	}
+
+	filter := bson.M{"id": incident.Id}
+	update := bson.M{"$set": bson.M{"acknowledged": true, "acknowledgedat": time.Now()}, "$push": bson.M{"timeline": timepoint}}
+
+	var updated incidents.Incident
+	err = database.FindOneAndUpdate("incidents", filter, update).Decode(&updated)
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// createIncident opens an incident for the team, assigns it to whoever is
+// on call, or the assignee set by event rules, and alerts them
+func createIncident(team auth.Team, incident incidents.Incident, result *rules.Result) (*incidents.Incident, error) {
+	incident.Status = incidents.StatusOpen
+	incident.CreatedAt = time.Now()
+	incident.UpdatedAt = time.Now()
+	incident.Acknowledged = false
+	incident.Resolved = false
+	incident.Timeline = []incidents.Timepoint{}
+	incident.AssignedTo = []auth.User{}
+	incident.AlertCount = 1
+
+	// create a timeline item for when incident is created
+	data := map[string]interface{}{
+		"createdby": team.TeamName,
+		"subtext":   fmt.Sprintf("Initiated by %s", team.TeamName),
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+	}
+	jsonString := string(jsonData)
+
+	// start populating the incident with main details
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	incident.Id = code
+	incident.Metadata = jsonString
+	incident.TeamId = team.TeamId
+	incident.Timeline = append(incident.Timeline, incidents.Timepoint{
+		Title:     "Incident Created",
+		CreatedAt: time.Now(),
+		Metadata:  jsonString,
+	})
+
+	if result != nil && len(result.Matched) > 0 {
+		data := map[string]interface{}{
+			"rules":   result.Matched,
+			"subtext": fmt.Sprintf("Event rules applied: %s", strings.Join(result.Matched, ", ")),
+		}
+		jsonData, err := json.Marshal(data)
+		if err != nil {
+			fmt.Println("Error marshalling JSON:", err)
+		}
+		incident.Timeline = append(incident.Timeline, incidents.Timepoint{
+			Title:     "Event Rules Applied",
+			CreatedAt: time.Now(),
+			Metadata:  string(jsonData),
+		})
+	}
+
+	if result != nil && result.Actions.Suppress {
+		return createSuppressed(incident, "Notifications suppressed by event rules")
+	}
+
+	// record the alert but leave everyone alone during planned maintenance
+	window, err := maintenance.Active(team.TeamId, time.Now(), incident.Source, incident.Severity)
+	if err != nil {
+		log.Println(err)
+	}
+	if window != nil {
+		incident.Maintenance = window.Id
+		return createSuppressed(incident, fmt.Sprintf("Notifications suppressed by maintenance window %s until %s", window.Name, window.End.Format(time.RFC1123)))
+	}
+
+	var text string
+	var severity string
+	switch incident.Severity {
+	case "Low":
+		severity = "Low 🟨"
+	case "Medium":
+		severity = "Medium 🟥"
+	case "High":
+		severity = "High 🆘"
+	default:
+		severity = "Unknown"
+	}
+
+	if result != nil && result.Actions.Assignee != "" {
+		var assignee auth.User
+		err := database.FindOne("users", bson.M{"code": result.Actions.Assignee, "teamId": team.TeamId}).Decode(&assignee)
+		if err != nil {
+			// the user may have left the team since, fall back to on-call
+			log.Printf("Error finding event rule assignee %s: %v", result.Actions.Assignee, err)
+		} else {
+			incident.AssignedTo = append(incident.AssignedTo, assignee)
+		}
+	}
+
+	// check who is on-call, falling through to the secondary layer
+	if len(incident.AssignedTo) == 0 {
+		responder, err := onCallResponder(team.TeamId)
+		if err != nil {
+			return nil, err
+		}
+		if responder != nil {
+			incident.AssignedTo = append(incident.AssignedTo, *responder)
+		}
+	}
+
+	if len(incident.AssignedTo) > 0 {
+		assignedToNames := make([]string, len(incident.AssignedTo))
+		for i, user := range incident.AssignedTo {
+			assignedToNames[i] = fmt.Sprintf("%s <%s>", user.Name, user.GithubHandle)
+		}
+		assignedToList := strings.Join(assignedToNames, ", ")
+		data := map[string]interface{}{
+			"assignedTo": assignedToList,
+			"subtext":    fmt.Sprintf("Assigned to: %s", assignedToList),
+		}
+
+		jsonData, err := json.Marshal(data)
+		if err != nil {
+			fmt.Println("Error marshalling JSON:", err)
+		}
+
+		jsonString := string(jsonData)
+		incident.Metadata = jsonString
+		incident.Timeline = append(incident.Timeline, incidents.Timepoint{
+			Title:     "Incident Assigned",
+			CreatedAt: time.Now(),
+			Metadata:  jsonString,
+		})
+		text = fmt.Sprintf("Incident #%s created and assigned to %s\n\n%s\n%s\nSeverity: %s", incident.Id, assignedToList, incident.Title, incident.Description, severity)
+	} else {
+		text = fmt.Sprintf("Incident #%s created and unassigned\n\n%s\n%s\nSeverity: %s", incident.Id, incident.Title, incident.Description, severity)
+	}
+	// an alert already flapping gets the consolidated page right away
+	var flapSubtext string
+	if incident.Flapping {
+		var timepoint incidents.Timepoint
+		timepoint, flapSubtext = flappingTimepoint(team)
+		incident.Timeline = append(incident.Timeline, timepoint)
+	}
+
+	data = map[string]interface{}{
+		"createdby": team.TeamName,
+		"subtext":   "Alert sent to everyone on-call and slack",
+	}
+
+	jsonData, err = json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+	}
+
+	jsonString = string(jsonData)
+
+	incident.Timeline = append(incident.Timeline, incidents.Timepoint{
+		Title:     "Alerted",
+		CreatedAt: time.Now(),
+		Metadata:  jsonString,
+	})
+
+	// Someone is on-call
+	_, err = database.InsertOne("incidents", incident)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, errDuplicateAlert
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("incident not created")
+	}
+
+	err = slack.Notify(&slack.NotifyParams{Text: text})
+	if err != nil {
+		log.Println(err)
+	}
+
+	if incident.Flapping {
+		pageFlapping(incident, flapSubtext)
+	} else if len(incident.AssignedTo) > 0 {
+		for _, user := range incident.AssignedTo {
+			notification.SendPage(fmt.Sprintf("You have been assigned to: \nIncident #%s\nTitle: %s\nDescription: %s\nSeverity: %s", incident.Id, incident.Title, incident.Description, incident.Severity), user, incident.Id)
+		}
+	}
+
+	return &incident, nil
+}
+
+// createSuppressed stores an incident without assigning or paging anyone,
+// noting why on its timeline
+func createSuppressed(incident incidents.Incident, reason string) (*incidents.Incident, error) {
+	data := map[string]interface{}{
+		"subtext": reason,
+	}
+	if incident.Maintenance != "" {
+		data["maintenance"] = incident.Maintenance
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+	}
+
+	incident.Suppressed = true
+	incident.Timeline = append(incident.Timeline, incidents.Timepoint{
+		Title:     "Suppressed 🔕",
+		CreatedAt: time.Now(),
+		Metadata:  string(jsonData),
+	})
+
+	_, err = database.InsertOne("incidents", incident)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, errDuplicateAlert
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("incident not created")
+	}
+	return &incident, nil
+}
+
+// onCallResponder returns whoever is on call for the team right now,
+// falling through to the secondary layer
+func onCallResponder(teamId string) (*auth.User, error) {
+	schedule, err := schedules.Responder(time.Now(), teamId)
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("can not get on-call engineer")
+	}
+	if schedule == nil {
+		return nil, nil
+	}
+
+	var scheduledUser auth.User
+	err = database.FindOne("users", bson.M{"email": schedule.User.Email}).Decode(&scheduledUser)
+	if err != nil {
+		return nil, errors.New("Invalid credentials")
+	}
+	return &scheduledUser, nil
+}
+
+// releaseAfterMaintenance pages the on-call responder for an incident that
+// was suppressed by a maintenance window once it is still open after the
+// window ended or was cancelled
+func releaseAfterMaintenance(team auth.Team, incident incidents.Incident) (*incidents.Incident, error) {
+	window, err := maintenance.Active(team.TeamId, time.Now(), incident.Source, incident.Severity)
+	if err != nil {
+		return nil, err
+	}
+	if window != nil {
+		return &incident, nil
+	}
+
+	responder, err := onCallResponder(team.TeamId)
+	if err != nil {
+		return nil, err
+	}
+
+	subtext := "Maintenance is over and the alert is still firing"
+	if responder != nil {
+		subtext = fmt.Sprintf("%s, assigned to %s", subtext, responder.Name)
+	}
+	data := map[string]interface{}{
+		"maintenance": incident.Maintenance,
+		"subtext":     subtext,
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+	}
+
+	timepoint := incidents.Timepoint{
+		Title:     "Maintenance Ended 🔔",
+		CreatedAt: time.Now(),
+		Metadata:  string(jsonData),
+	}
+	update := bson.M{"$set": bson.M{"suppressed": false, "updatedat": time.Now()}, "$push": bson.M{"timeline": timepoint}}
+	if responder != nil {
+		update["$push"] = bson.M{"timeline": timepoint, "assignedto": *responder}
+	}
+
+	// only the first duplicate or sweep after the window releases it
+	var updated incidents.Incident
+	err = database.FindOneAndUpdate("incidents", bson.M{"id": incident.Id, "suppressed": true}, update).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return &incident, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("Incident #%s is no longer suppressed by maintenance\n\n%s\n%s\nSeverity: %s", updated.Id, updated.Title, updated.Description, updated.Severity)
+	if err := slack.Notify(&slack.NotifyParams{Text: text}); err != nil {
+		log.Println(err)
+	}
+	if responder != nil {
+		notification.SendPage(fmt.Sprintf("You have been assigned to: \nIncident #%s\nTitle: %s\nDescription: %s\nSeverity: %s", updated.Id, updated.Title, updated.Description, updated.Severity), *responder, updated.Id)
+	}
+	return &updated, nil
+}
+
+// ReleaseEndedMaintenance releases the open incidents whose maintenance
+// window has ended or was cancelled, so alerts that fired once during the
+// window or carry no dedup key are not left suppressed for good
+func ReleaseEndedMaintenance(now time.Time) {
+	ctx := context.Background()
+	cursor, err := database.Find("incidents", bson.M{"resolved": false, "suppressed": true, "maintenance": bson.M{"$ne": ""}})
+	if err != nil {
+		log.Printf("Error listing suppressed incidents: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var list []incidents.Incident
+	if err := cursor.All(ctx, &list); err != nil {
+		log.Printf("Error decoding suppressed incidents: %v", err)
+		return
+	}
+
+	for _, incident := range list {
+		var window maintenance.Window
+		err := database.FindOne("maintenancewindows", bson.M{"teamid": incident.TeamId, "id": incident.Maintenance}).Decode(&window)
+		if err != nil && err != mongo.ErrNoDocuments {
+			log.Printf("Error finding maintenance window %s: %v", incident.Maintenance, err)
+			continue
+		}
+		// a deleted window no longer holds anything back
+		if err == nil && !window.Cancelled && now.Before(window.End) {
+			continue
+		}
+
+		var team auth.Team
+		if err := database.FindOne("teams", bson.M{"teamId": incident.TeamId}).Decode(&team); err != nil {
+			log.Printf("Error finding team %s: %v", incident.TeamId, err)
+			continue
+		}
+		if _, err := releaseAfterMaintenance(team, incident); err != nil {
+			log.Printf("Error releasing incident %s after maintenance: %v", incident.Id, err)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/maintenance"
+	"issue-reporting/utils"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var connectOnce sync.Once
+var connectErr error
+
+// testTeam connects to the database in MONGODB and returns a throwaway
+// team whose incidents and alert states are removed after the test.
+func testTeam(t *testing.T) auth.Team {
+	t.Helper()
+	if os.Getenv("MONGODB") == "" {
+		t.Skip("MONGODB is not set")
+	}
+	connectOnce.Do(func() {
+		if connectErr = database.Connect(); connectErr == nil {
+			connectErr = EnsureDedupIndex()
+		}
+	})
+	if connectErr != nil {
+		t.Fatal(connectErr)
+	}
+
+	id, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := auth.Team{TeamId: "test-" + id, TeamName: "Test " + id}
+	t.Cleanup(func() {
+		db := database.GetDatabase().Database("IssueReporting")
+		db.Collection("incidents").DeleteMany(context.Background(), bson.M{"teamid": team.TeamId})
+		db.Collection("alertstates").DeleteMany(context.Background(), bson.M{"teamid": team.TeamId})
+		db.Collection("maintenancewindows").DeleteMany(context.Background(), bson.M{"teamid": team.TeamId})
+		db.Collection("groupingconfigs").DeleteMany(context.Background(), bson.M{"teamid": team.TeamId})
+	})
+	return team
+}
+
+func openIncidents(t *testing.T, team auth.Team, dedupKey string) []incidents.Incident {
+	t.Helper()
+	cursor, err := database.Find("incidents", bson.M{"teamid": team.TeamId, "dedupkey": dedupKey, "resolved": false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []incidents.Incident
+	if err := cursor.All(context.Background(), &list); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestIngestLifecycle(t *testing.T) {
+	team := testTeam(t)
+	trigger := Event{Title: "Disk full", Severity: incidents.SeverityHigh, EventType: EventTrigger, DedupKey: "disk-full"}
+
+	created, outcome, err := Ingest(team, trigger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeCreated || created.AlertCount != 1 {
+		t.Fatalf("trigger: outcome %s, count %d", outcome, created.AlertCount)
+	}
+
+	duplicate, outcome, err := Ingest(team, trigger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeDeduplicated || duplicate.Id != created.Id || duplicate.AlertCount != 2 {
+		t.Fatalf("duplicate: outcome %s, incident %s, count %d", outcome, duplicate.Id, duplicate.AlertCount)
+	}
+
+	acknowledged, outcome, err := Ingest(team, Event{EventType: EventAcknowledge, DedupKey: "disk-full"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeAcknowledged || acknowledged.Id != created.Id || !acknowledged.Acknowledged {
+		t.Fatalf("acknowledge: outcome %s, incident %s, acknowledged %v", outcome, acknowledged.Id, acknowledged.Acknowledged)
+	}
+
+	resolved, outcome, err := Ingest(team, Event{EventType: EventResolve, DedupKey: "disk-full"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeResolved || resolved.Id != created.Id || !resolved.Resolved {
+		t.Fatalf("resolve: outcome %s, incident %s, resolved %v", outcome, resolved.Id, resolved.Resolved)
+	}
+
+	// nothing is open for the key any more
+	_, outcome, err = Ingest(team, Event{EventType: EventResolve, DedupKey: "disk-full"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeIgnored {
+		t.Errorf("second resolve: outcome %s, want %s", outcome, OutcomeIgnored)
+	}
+
+	reopened, outcome, err := Ingest(team, trigger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeCreated || reopened.Id == created.Id {
+		t.Errorf("trigger after resolve: outcome %s, incident %s", outcome, reopened.Id)
+	}
+}
+
+func TestIngestConcurrentTriggers(t *testing.T) {
+	team := testTeam(t)
+	trigger := Event{Title: "CPU high", EventType: EventTrigger, DedupKey: "cpu-high"}
+
+	const triggers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, triggers)
+	for i := 0; i < triggers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := Ingest(team, trigger); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	open := openIncidents(t, team, "cpu-high")
+	if len(open) != 1 {
+		t.Fatalf("%d open incidents for one dedup key, want 1", len(open))
+	}
+	if open[0].AlertCount != triggers {
+		t.Errorf("alert count %d, want %d", open[0].AlertCount, triggers)
+	}
+}
+
+func TestIngestReleasesAfterMaintenance(t *testing.T) {
+	team := testTeam(t)
+	now := time.Now()
+	window := maintenance.Window{Id: "test-window", TeamId: team.TeamId, Name: "Upgrade", Start: now.Add(-time.Hour), End: now.Add(time.Hour), CreatedAt: now}
+	if _, err := database.InsertOne("maintenancewindows", window); err != nil {
+		t.Fatal(err)
+	}
+	trigger := Event{Title: "Replica lag", EventType: EventTrigger, DedupKey: "replica-lag"}
+
+	created, _, err := Ingest(team, trigger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.Suppressed || created.Maintenance != window.Id {
+		t.Fatalf("created during maintenance: suppressed %v, maintenance %q", created.Suppressed, created.Maintenance)
+	}
+
+	// still inside the window, the duplicate stays quiet
+	duplicate, _, err := Ingest(team, trigger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !duplicate.Suppressed {
+		t.Fatal("duplicate during maintenance released the incident")
+	}
+
+	if _, err := database.UpdateOne("maintenancewindows", bson.M{"teamid": team.TeamId, "id": window.Id}, bson.M{"$set": bson.M{"cancelled": true}}); err != nil {
+		t.Fatal(err)
+	}
+	released, outcome, err := Ingest(team, trigger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeDeduplicated || released.Id != created.Id || released.Suppressed {
+		t.Fatalf("duplicate after maintenance: outcome %s, incident %s, suppressed %v", outcome, released.Id, released.Suppressed)
+	}
+	last := released.Timeline[len(released.Timeline)-1]
+	if last.Title != "Maintenance Ended 🔔" {
+		t.Errorf("last timeline entry %q", last.Title)
+	}
+}
+
+func TestIngestCreatesFlappingIncident(t *testing.T) {
+	team := testTeam(t)
+	// the alert flapped before and its incident was closed by hand
+	state := AlertState{TeamId: team.TeamId, DedupKey: "link-down", State: EventResolve, Flapping: true, LastTransition: time.Now()}
+	if _, err := database.InsertOne("alertstates", state); err != nil {
+		t.Fatal(err)
+	}
+
+	created, outcome, err := Ingest(team, Event{Title: "Link down", EventType: EventTrigger, DedupKey: "link-down"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != OutcomeCreated || !created.Flapping {
+		t.Fatalf("trigger: outcome %s, flapping %v", outcome, created.Flapping)
+	}
+	found := false
+	for _, timepoint := range created.Timeline {
+		found = found || timepoint.Title == "Flapping 🔃"
+	}
+	if !found {
+		t.Error("incident created while flapping has no flapping entry on its timeline")
+	}
+}
+
+func TestReleaseEndedMaintenance(t *testing.T) {
+	team := testTeam(t)
+	if _, err := database.InsertOne("teams", team); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		database.GetDatabase().Database("IssueReporting").Collection("teams").DeleteMany(context.Background(), bson.M{"teamId": team.TeamId})
+	})
+	now := time.Now()
+	window := maintenance.Window{Id: "test-window", TeamId: team.TeamId, Name: "Upgrade", Start: now.Add(-time.Hour), End: now.Add(time.Hour), CreatedAt: now}
+	if _, err := database.InsertOne("maintenancewindows", window); err != nil {
+		t.Fatal(err)
+	}
+
+	// fires once during the window and has no dedup key to be repeated by
+	created, _, err := Ingest(team, Event{Title: "Backup failed", EventType: EventTrigger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.Suppressed {
+		t.Fatal("incident created during maintenance is not suppressed")
+	}
+
+	// the window is still running
+	ReleaseEndedMaintenance(time.Now())
+	var incident incidents.Incident
+	if err := database.FindOne("incidents", bson.M{"id": created.Id}).Decode(&incident); err != nil {
+		t.Fatal(err)
+	}
+	if !incident.Suppressed {
+		t.Fatal("incident released while its window is running")
+	}
+
+	if _, err := database.UpdateOne("maintenancewindows", bson.M{"teamid": team.TeamId, "id": window.Id}, bson.M{"$set": bson.M{"cancelled": true}}); err != nil {
+		t.Fatal(err)
+	}
+	ReleaseEndedMaintenance(time.Now())
+	if err := database.FindOne("incidents", bson.M{"id": created.Id}).Decode(&incident); err != nil {
+		t.Fatal(err)
+	}
+	if incident.Suppressed {
+		t.Fatal("incident still suppressed after its window was cancelled")
+	}
+	last := incident.Timeline[len(incident.Timeline)-1]
+	if last.Title != "Maintenance Ended 🔔" {
+		t.Errorf("last timeline entry %q", last.Title)
+	}
+}
@@ -0,0 +1,428 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/utils"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxLinkedLogs caps how many log ids a rule keeps on its incident
+const maxLinkedLogs = 100
+
+// LogRule turns ingested logs into incidents. A log matches when it has the
+// rule's App and Slug (if set) and its title and description match the
+// Title and Description regular expressions (if set). The rule fires on
+// every match, or once more than Threshold matching logs arrive within
+// Window minutes.
+type LogRule struct {
+	Id          string             `json:"id"`
+	TeamId      string             `json:"teamId"`
+	Name        string             `json:"name"`
+	App         string             `json:"app"`
+	Slug        string             `json:"slug"`
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Threshold   int                `json:"threshold"`
+	Window      int                `json:"window"`
+	Severity    incidents.Severity `json:"severity"`
+	Disabled    bool               `json:"disabled"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+// matcher compiles the rule into a test for logs it looks for
+func (r LogRule) matcher() (func(incidents.Log) bool, error) {
+	var title, description *regexp.Regexp
+	var err error
+	if r.Title != "" {
+		if title, err = regexp.Compile(r.Title); err != nil {
+			return nil, err
+		}
+	}
+	if r.Description != "" {
+		if description, err = regexp.Compile(r.Description); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(logx incidents.Log) bool {
+		if r.App != "" && r.App != logx.App {
+			return false
+		}
+		if r.Slug != "" && r.Slug != logx.Slug {
+			return false
+		}
+		if title != nil && !title.MatchString(logx.Title) {
+			return false
+		}
+		if description != nil && !description.MatchString(logx.Description) {
+			return false
+		}
+		return true
+	}, nil
+}
+
+// StoreLogs saves logs for the team and runs the team's log rules over
+// them. Rule failures are logged rather than returned, the logs are stored
+// either way.
+func StoreLogs(team auth.Team, logs []incidents.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, len(logs))
+	for i := range logs {
+		logs[i].TeamId = team.TeamId
+		documents[i] = logs[i]
+	}
+	if _, err := database.InsertMany("logs", documents); err != nil {
+		return err
+	}
+
+	if err := EvaluateLogRules(team, logs, time.Now()); err != nil {
+		log.Printf("Error evaluating log rules for team %s: %v", team.TeamId, err)
+	}
+	return nil
+}
+
+// EvaluateLogRules fires the team's enabled rules that the new logs match
+func EvaluateLogRules(team auth.Team, logs []incidents.Log, now time.Time) error {
+	ctx := context.Background()
+	cursor, err := database.Find("logrules", bson.M{"teamid": team.TeamId, "disabled": false})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var rules []LogRule
+	if err := cursor.All(ctx, &rules); err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		matches, err := rule.matcher()
+		if err != nil {
+			log.Printf("Error compiling log rule %s: %v", rule.Id, err)
+			continue
+		}
+
+		// newest first, like matchingSince
+		var matched []incidents.Log
+		for i := len(logs) - 1; i >= 0; i-- {
+			if matches(logs[i]) {
+				matched = append(matched, logs[i])
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		if rule.Threshold > 0 {
+			matched, err = matchingSince(rule, matches, now.Add(-time.Duration(rule.Window)*time.Minute))
+			if err != nil {
+				log.Printf("Error counting logs for rule %s: %v", rule.Id, err)
+				continue
+			}
+			if len(matched) <= rule.Threshold {
+				continue
+			}
+		}
+
+		if err := fireLogRule(team, rule, matched); err != nil {
+			log.Printf("Error firing log rule %s: %v", rule.Id, err)
+		}
+	}
+	return nil
+}
+
+// matchingSince returns the team's logs since the given time that the rule
+// matches, newest first
+func matchingSince(rule LogRule, matches func(incidents.Log) bool, since time.Time) ([]incidents.Log, error) {
+	ctx := context.Background()
+	filter := bson.M{"teamid": rule.TeamId, "createdat": bson.M{"$gte": since}}
+	if rule.App != "" {
+		filter["app"] = rule.App
+	}
+	if rule.Slug != "" {
+		filter["slug"] = rule.Slug
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdat", Value: -1}})
+	cursor, err := database.GetDatabase().Database("IssueReporting").Collection("logs").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var matched []incidents.Log
+	for cursor.Next(ctx) {
+		var logx incidents.Log
+		if err := cursor.Decode(&logx); err != nil {
+			return nil, err
+		}
+		if matches(logx) {
+			matched = append(matched, logx)
+		}
+	}
+	return matched, cursor.Err()
+}
+
+// fireLogRule opens the rule's incident, or counts the alert on it while it
+// is open, and links the logs that triggered it
+func fireLogRule(team auth.Team, rule LogRule, matched []incidents.Log) error {
+	var ids []string
+	for _, logx := range matched {
+		if len(ids) == maxLinkedLogs {
+			break
+		}
+		ids = append(ids, logx.Id)
+	}
+
+	latest := matched[0]
+	description := fmt.Sprintf("%d matching logs", len(matched))
+	if rule.Threshold > 0 {
+		description = fmt.Sprintf("%d matching logs in the last %d minutes (threshold %d)", len(matched), rule.Window, rule.Threshold)
+	}
+	description += fmt.Sprintf("\nLatest: %s\n%s", latest.Title, latest.Description)
+
+	incident, outcome, err := Ingest(team, Event{
+		Title:       fmt.Sprintf("Log rule %s matched", rule.Name),
+		Description: description,
+		Severity:    rule.Severity,
+		EventType:   EventTrigger,
+		DedupKey:    "logrule:" + rule.Id,
+		Source:      latest.App,
+		Details:     map[string]interface{}{"rule": rule.Id, "logs": ids},
+	})
+	if err != nil {
+		return err
+	}
+
+	if outcome == OutcomeDeduplicated && incident != nil {
+		update := bson.M{"$push": bson.M{"details.logs": bson.M{"$each": ids, "$slice": -maxLinkedLogs}}}
+		_, err = database.UpdateOne("incidents", bson.M{"id": incident.Id}, update)
+	}
+	return err
+}
+
+func CreateLogRule(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var rule LogRule
+	if err := c.BodyParser(&rule); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rule.Severity == "" {
+		rule.Severity = incidents.SeverityMedium
+	}
+	if err := VerifyLogRule(rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	rule.Id = code
+	rule.TeamId = user.TeamId
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+
+	_, err = database.InsertOne("logrules", rule)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "log rule not created")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "log rule created",
+		"rule":    rule,
+	})
+}
+
+func GetLogRules(c *fiber.Ctx) error {
+	ctx := context.Background()
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	cursor, err := database.Find("logrules", bson.M{"teamid": user.TeamId})
+	if err != nil {
+		return fmt.Errorf("error finding log rules: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var rules []LogRule
+	if err := cursor.All(ctx, &rules); err != nil {
+		return fmt.Errorf("error decoding log rules: %v", err)
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "log rules data",
+		"rules":   rules,
+	})
+}
+
+func GetLogRule(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var rule LogRule
+	err = database.FindOne("logrules", bson.M{"id": c.Params("id"), "teamid": user.TeamId}).Decode(&rule)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusNotFound, "No log rule found")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "log rule data",
+		"rule":    &rule,
+	})
+}
+
+func UpdateLogRule(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var body LogRule
+	if err := c.BodyParser(&body); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if body.Severity == "" {
+		body.Severity = incidents.SeverityMedium
+	}
+	if err := VerifyLogRule(body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+	update := bson.M{"$set": bson.M{
+		"name":        body.Name,
+		"app":         body.App,
+		"slug":        body.Slug,
+		"title":       body.Title,
+		"description": body.Description,
+		"threshold":   body.Threshold,
+		"window":      body.Window,
+		"severity":    body.Severity,
+		"disabled":    body.Disabled,
+		"updatedat":   time.Now(),
+	}}
+
+	var rule LogRule
+	err = database.FindOneAndUpdate("logrules", filter, update).Decode(&rule)
+	if err != nil {
+		return fiber.NewError(fiber.StatusNoContent, err.Error())
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "log rule updated",
+		"rule":    &rule,
+	})
+}
+
+func DeleteLogRule(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+
+	var rule LogRule
+	err = database.FindOne("logrules", filter).Decode(&rule)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusExpectationFailed, "No log rule found")
+	}
+
+	_, err = database.InsertOne("deletedlogrules", rule)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	_, err = database.DeleteOne("logrules", filter)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "log rule deleted",
+		"rule":    &rule,
+	})
+}
+
+func VerifyLogRule(rule LogRule) error {
+	if rule.Name == "" {
+		return errors.New("log rule name is required")
+	}
+	if rule.App == "" && rule.Slug == "" && rule.Title == "" && rule.Description == "" {
+		return errors.New("log rule needs at least one of app, slug, title or description")
+	}
+	for name, pattern := range map[string]string{"title": rule.Title, "description": rule.Description} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	if rule.Threshold < 0 {
+		return errors.New("threshold cannot be negative")
+	}
+	if rule.Threshold > 0 && rule.Window <= 0 {
+		return errors.New("window must be greater than zero when a threshold is set")
+	}
+	switch rule.Severity {
+	case incidents.SeverityLow, incidents.SeverityMedium, incidents.SeverityHigh:
+	default:
+		return errors.New("severity must be Low, Medium or High")
+	}
+	return nil
+}
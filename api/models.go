@@ -0,0 +1,38 @@
+package api
+
+import "issue-reporting/incidents"
+
+// Event is an alert sent to the ingestion API. Events sharing a DedupKey
+// attach to the same unresolved incident instead of opening new ones.
+type Event struct {
+	Title       string                 `json:"title"`
+	Description string                 `json:"description"`
+	Severity    incidents.Severity     `json:"severity"`
+	EventType   EventType              `json:"event_type"`
+	DedupKey    string                 `json:"dedup_key"`
+	Source      string                 `json:"source"`
+	Details     map[string]interface{} `json:"details"`
+	Integration string                 `json:"-"`
+}
+
+type EventType string
+
+const (
+	EventTrigger     EventType = "trigger"
+	EventAcknowledge EventType = "acknowledge"
+	EventResolve     EventType = "resolve"
+)
+
+// Outcome says what an ingested event did
+type Outcome string
+
+const (
+	OutcomeCreated      Outcome = "created"
+	OutcomeDeduplicated Outcome = "deduplicated"
+	OutcomeAcknowledged Outcome = "acknowledged"
+	OutcomeResolved     Outcome = "resolved"
+	OutcomeIgnored      Outcome = "ignored"
+	OutcomeDropped      Outcome = "dropped"
+	OutcomeGrouped      Outcome = "grouped"
+	OutcomeHeld         Outcome = "held"
+)
@@ -0,0 +1,375 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/utils"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpRecord is an OpenTelemetry log record with the resource and scope it
+// was sent under
+type otlpRecord struct {
+	Resource       map[string]interface{} `json:"resource"`
+	Scope          string                 `json:"scope,omitempty"`
+	Time           time.Time              `json:"-"`
+	SeverityNumber int                    `json:"severityNumber,omitempty"`
+	SeverityText   string                 `json:"severityText,omitempty"`
+	Body           interface{}            `json:"-"`
+	Attributes     map[string]interface{} `json:"attributes,omitempty"`
+	TraceId        string                 `json:"traceId,omitempty"`
+	SpanId         string                 `json:"spanId,omitempty"`
+}
+
+// otlpSeverities names the ranges of OpenTelemetry severity numbers
+var otlpSeverities = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// ToLog maps a record onto a log: service.name becomes App and the
+// severity Slug
+func (r otlpRecord) ToLog() (incidents.Log, error) {
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		return incidents.Log{}, err
+	}
+
+	var body string
+	switch value := r.Body.(type) {
+	case nil:
+	case string:
+		body = value
+	default:
+		data, _ := json.Marshal(value)
+		body = string(data)
+	}
+
+	title, _, _ := strings.Cut(body, "\n")
+	title = utils.Truncate(title, 200)
+
+	slug := strings.ToLower(r.SeverityText)
+	if slug == "" && r.SeverityNumber >= 1 && r.SeverityNumber <= 24 {
+		slug = otlpSeverities[(r.SeverityNumber-1)/4]
+	}
+
+	app, _ := r.Resource["service.name"].(string)
+
+	metadata, err := json.Marshal(r)
+	if err != nil {
+		return incidents.Log{}, err
+	}
+
+	return incidents.Log{
+		Id:          code,
+		Title:       title,
+		Description: body,
+		CreatedAt:   r.Time,
+		Metadata:    string(metadata),
+		Slug:        slug,
+		App:         app,
+	}, nil
+}
+
+// ReceiveOTLPLogs is an OTLP/HTTP logs receiver. Exporters point their logs
+// endpoint at /api/v1/otlp/v1/logs with the team API key as bearer token
+// and send protobuf or JSON, gzipped or not.
+func ReceiveOTLPLogs(c *fiber.Ctx) error {
+	teamId := c.Locals("teamId").(string)
+
+	var team auth.Team
+	err := database.FindOne("teams", bson.M{"teamId": teamId}).Decode(&team)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Invalid API key"})
+	}
+
+	isJSON := strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON)
+	records, err := decodeOTLP(c.Body(), isJSON, strings.EqualFold(c.Get(fiber.HeaderContentEncoding), "gzip"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "invalid OTLP logs request: " + err.Error(),
+		})
+	}
+	if len(records) > maxLogBatch {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error":   "Request Entity Too Large",
+			"message": fmt.Sprintf("at most %d logs can be sent at once", maxLogBatch),
+		})
+	}
+
+	now := time.Now()
+	logs := make([]incidents.Log, 0, len(records))
+	for _, record := range records {
+		if record.Time.IsZero() {
+			record.Time = now
+		}
+		logx, err := record.ToLog()
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		logs = append(logs, logx)
+	}
+
+	if err := StoreLogs(team, logs); err != nil {
+		log.Println(err)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"message": "logs not created",
+			"status":  false,
+		})
+	}
+
+	// an empty ExportLogsServiceResponse
+	if isJSON {
+		return c.Status(200).JSON(fiber.Map{})
+	}
+	c.Set(fiber.HeaderContentType, "application/x-protobuf")
+	return c.Status(200).Send(nil)
+}
+
+// decodeOTLP reads the log records of an export request body
+func decodeOTLP(body []byte, isJSON bool, gzipped bool) ([]otlpRecord, error) {
+	if gzipped {
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		body, err = io.ReadAll(io.LimitReader(reader, 64*1024*1024))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if isJSON {
+		return decodeOTLPJSON(body)
+	}
+	return decodeOTLPProtobuf(body)
+}
+
+// OTLP/JSON encoding, see the opentelemetry-proto repository
+
+type otlpJSONRequest struct {
+	ResourceLogs []struct {
+		Resource struct {
+			Attributes []otlpJSONKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []struct {
+			Scope struct {
+				Name string `json:"name"`
+			} `json:"scope"`
+			LogRecords []struct {
+				TimeUnixNano         json.RawMessage    `json:"timeUnixNano"`
+				ObservedTimeUnixNano json.RawMessage    `json:"observedTimeUnixNano"`
+				SeverityNumber       int                `json:"severityNumber"`
+				SeverityText         string             `json:"severityText"`
+				Body                 *otlpJSONAnyValue  `json:"body"`
+				Attributes           []otlpJSONKeyValue `json:"attributes"`
+				TraceId              string             `json:"traceId"`
+				SpanId               string             `json:"spanId"`
+			} `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+type otlpJSONKeyValue struct {
+	Key   string            `json:"key"`
+	Value *otlpJSONAnyValue `json:"value"`
+}
+
+type otlpJSONAnyValue struct {
+	StringValue *string         `json:"stringValue"`
+	BoolValue   *bool           `json:"boolValue"`
+	IntValue    json.RawMessage `json:"intValue"`
+	DoubleValue *float64        `json:"doubleValue"`
+	ArrayValue  *struct {
+		Values []*otlpJSONAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []otlpJSONKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+	BytesValue *string `json:"bytesValue"`
+}
+
+func (v *otlpJSONAnyValue) value() interface{} {
+	switch {
+	case v == nil:
+		return nil
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		n, _ := jsonInt(v.IntValue)
+		return n
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.ArrayValue != nil:
+		values := make([]interface{}, len(v.ArrayValue.Values))
+		for i, item := range v.ArrayValue.Values {
+			values[i] = item.value()
+		}
+		return values
+	case v.KvlistValue != nil:
+		return jsonAttributes(v.KvlistValue.Values)
+	case v.BytesValue != nil:
+		return *v.BytesValue
+	}
+	return nil
+}
+
+func jsonAttributes(pairs []otlpJSONKeyValue) map[string]interface{} {
+	if len(pairs) == 0 {
+		return nil
+	}
+	attributes := make(map[string]interface{}, len(pairs))
+	for _, pair := range pairs {
+		attributes[pair.Key] = pair.Value.value()
+	}
+	return attributes
+}
+
+// jsonInt reads 64 bit integers, which OTLP/JSON sends as strings
+func jsonInt(raw json.RawMessage) (int64, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+	text := strings.Trim(string(raw), `"`)
+	if text == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(text, 10, 64)
+}
+
+func unixNano(nanos int64) time.Time {
+	if nanos <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+func decodeOTLPJSON(body []byte) ([]otlpRecord, error) {
+	var request otlpJSONRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, err
+	}
+
+	var records []otlpRecord
+	for _, resourceLogs := range request.ResourceLogs {
+		resource := jsonAttributes(resourceLogs.Resource.Attributes)
+		for _, scopeLogs := range resourceLogs.ScopeLogs {
+			for _, logRecord := range scopeLogs.LogRecords {
+				timestamp, err := jsonInt(logRecord.TimeUnixNano)
+				if err != nil {
+					return nil, fmt.Errorf("timeUnixNano: %v", err)
+				}
+				if timestamp == 0 {
+					if timestamp, err = jsonInt(logRecord.ObservedTimeUnixNano); err != nil {
+						return nil, fmt.Errorf("observedTimeUnixNano: %v", err)
+					}
+				}
+				records = append(records, otlpRecord{
+					Resource:       resource,
+					Scope:          scopeLogs.Scope.Name,
+					Time:           unixNano(timestamp),
+					SeverityNumber: logRecord.SeverityNumber,
+					SeverityText:   logRecord.SeverityText,
+					Body:           logRecord.Body.value(),
+					Attributes:     jsonAttributes(logRecord.Attributes),
+					TraceId:        logRecord.TraceId,
+					SpanId:         logRecord.SpanId,
+				})
+			}
+		}
+	}
+	return records, nil
+}
+
+// OTLP protobuf encoding, with the types generated from opentelemetry-proto
+
+func decodeOTLPProtobuf(body []byte) ([]otlpRecord, error) {
+	var request collogs.ExportLogsServiceRequest
+	if err := proto.Unmarshal(body, &request); err != nil {
+		return nil, err
+	}
+
+	var records []otlpRecord
+	for _, resourceLogs := range request.ResourceLogs {
+		resource := protoAttributes(resourceLogs.GetResource().GetAttributes())
+		for _, scopeLogs := range resourceLogs.ScopeLogs {
+			for _, logRecord := range scopeLogs.LogRecords {
+				timestamp := logRecord.TimeUnixNano
+				if timestamp == 0 {
+					timestamp = logRecord.ObservedTimeUnixNano
+				}
+				record := otlpRecord{
+					Resource:       resource,
+					Scope:          scopeLogs.GetScope().GetName(),
+					Time:           unixNano(int64(timestamp)),
+					SeverityNumber: int(logRecord.SeverityNumber),
+					SeverityText:   logRecord.SeverityText,
+					Body:           protoValue(logRecord.Body),
+					Attributes:     protoAttributes(logRecord.Attributes),
+				}
+				if len(logRecord.TraceId) > 0 {
+					record.TraceId = hex.EncodeToString(logRecord.TraceId)
+				}
+				if len(logRecord.SpanId) > 0 {
+					record.SpanId = hex.EncodeToString(logRecord.SpanId)
+				}
+				records = append(records, record)
+			}
+		}
+	}
+	return records, nil
+}
+
+func protoAttributes(pairs []*commonpb.KeyValue) map[string]interface{} {
+	if len(pairs) == 0 {
+		return nil
+	}
+	attributes := make(map[string]interface{}, len(pairs))
+	for _, pair := range pairs {
+		attributes[pair.Key] = protoValue(pair.Value)
+	}
+	return attributes
+}
+
+// protoValue reads an AnyValue the way decodeOTLPJSON does, bytes as base64
+func protoValue(v *commonpb.AnyValue) interface{} {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return value.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return value.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return value.DoubleValue
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]interface{}, len(value.ArrayValue.GetValues()))
+		for i, item := range value.ArrayValue.GetValues() {
+			values[i] = protoValue(item)
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		return protoAttributes(value.KvlistValue.GetValues())
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(value.BytesValue)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func stringValue(s string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+}
+
+// otlpFixture is the same export request as otlpFixtureJSON
+func otlpFixture() *collogs.ExportLogsServiceRequest {
+	return &collogs.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				{Key: "service.name", Value: stringValue("checkout")},
+				{Key: "host.name", Value: stringValue("web-1")},
+			}},
+			ScopeLogs: []*logspb.ScopeLogs{{
+				Scope: &commonpb.InstrumentationScope{Name: "checkout.payments"},
+				LogRecords: []*logspb.LogRecord{
+					{
+						TimeUnixNano:   1713187211000000000,
+						SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+						SeverityText:   "ERROR",
+						Body:           stringValue("payment declined\nstack trace follows"),
+						Attributes: []*commonpb.KeyValue{
+							{Key: "http.status_code", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 502}}},
+							{Key: "retry", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: true}}},
+							{Key: "latency", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: 1.5}}},
+							{Key: "raw", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BytesValue{BytesValue: []byte("hi")}}},
+							{Key: "tags", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{
+								Values: []*commonpb.AnyValue{stringValue("eu"), stringValue("card")},
+							}}}},
+							{Key: "card", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{
+								Values: []*commonpb.KeyValue{{Key: "brand", Value: stringValue("visa")}},
+							}}}},
+						},
+						TraceId: []byte{0x5b, 0x8e, 0xff, 0xf7, 0x98, 0x03, 0x81, 0x03, 0xd2, 0x69, 0xb6, 0x33, 0x81, 0x3f, 0xc6, 0x0c},
+						SpanId:  []byte{0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x74},
+					},
+					{
+						// only the collector's observed time is set
+						ObservedTimeUnixNano: 1713187212000000000,
+						SeverityNumber:       logspb.SeverityNumber_SEVERITY_NUMBER_WARN2,
+						Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{
+							Values: []*commonpb.KeyValue{{Key: "event", Value: stringValue("slow query")}},
+						}}},
+					},
+					{},
+				},
+			}},
+		}},
+	}
+}
+
+const otlpFixtureJSON = `{
+	"resourceLogs": [{
+		"resource": {"attributes": [
+			{"key": "service.name", "value": {"stringValue": "checkout"}},
+			{"key": "host.name", "value": {"stringValue": "web-1"}}
+		]},
+		"scopeLogs": [{
+			"scope": {"name": "checkout.payments"},
+			"logRecords": [
+				{
+					"timeUnixNano": "1713187211000000000",
+					"severityNumber": 17,
+					"severityText": "ERROR",
+					"body": {"stringValue": "payment declined\nstack trace follows"},
+					"attributes": [
+						{"key": "http.status_code", "value": {"intValue": "502"}},
+						{"key": "retry", "value": {"boolValue": true}},
+						{"key": "latency", "value": {"doubleValue": 1.5}},
+						{"key": "raw", "value": {"bytesValue": "aGk="}},
+						{"key": "tags", "value": {"arrayValue": {"values": [{"stringValue": "eu"}, {"stringValue": "card"}]}}},
+						{"key": "card", "value": {"kvlistValue": {"values": [{"key": "brand", "value": {"stringValue": "visa"}}]}}}
+					],
+					"traceId": "5b8efff798038103d269b633813fc60c",
+					"spanId": "eee19b7ec3c1b174"
+				},
+				{
+					"observedTimeUnixNano": "1713187212000000000",
+					"severityNumber": 14,
+					"body": {"kvlistValue": {"values": [{"key": "event", "value": {"stringValue": "slow query"}}]}}
+				},
+				{}
+			]
+		}]
+	}]
+}`
+
+func TestDecodeOTLPProtobuf(t *testing.T) {
+	body, err := proto.Marshal(otlpFixture())
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := decodeOTLP(body, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("%d records, want 3", len(records))
+	}
+
+	first := records[0]
+	if first.Body != "payment declined\nstack trace follows" {
+		t.Errorf("body %q", first.Body)
+	}
+	if !first.Time.Equal(time.Unix(0, 1713187211000000000)) {
+		t.Errorf("time %s", first.Time)
+	}
+	if first.Scope != "checkout.payments" || first.Resource["service.name"] != "checkout" {
+		t.Errorf("scope %q, resource %v", first.Scope, first.Resource)
+	}
+	wantAttributes := map[string]interface{}{
+		"http.status_code": int64(502),
+		"retry":            true,
+		"latency":          1.5,
+		"raw":              "aGk=",
+		"tags":             []interface{}{"eu", "card"},
+		"card":             map[string]interface{}{"brand": "visa"},
+	}
+	if !reflect.DeepEqual(first.Attributes, wantAttributes) {
+		t.Errorf("attributes %#v, want %#v", first.Attributes, wantAttributes)
+	}
+	if first.TraceId != "5b8efff798038103d269b633813fc60c" || first.SpanId != "eee19b7ec3c1b174" {
+		t.Errorf("trace id %q, span id %q", first.TraceId, first.SpanId)
+	}
+
+	second := records[1]
+	if !second.Time.Equal(time.Unix(0, 1713187212000000000)) {
+		t.Errorf("observed time fallback: %s", second.Time)
+	}
+	if !reflect.DeepEqual(second.Body, map[string]interface{}{"event": "slow query"}) {
+		t.Errorf("structured body %#v", second.Body)
+	}
+
+	// nothing set at all leaves the time for the receiver to fill in
+	if !records[2].Time.IsZero() || records[2].Body != nil {
+		t.Errorf("empty record: time %s, body %#v", records[2].Time, records[2].Body)
+	}
+}
+
+func TestDecodeOTLPJSONMatchesProtobuf(t *testing.T) {
+	body, err := proto.Marshal(otlpFixture())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromProtobuf, err := decodeOTLP(body, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := decodeOTLP([]byte(otlpFixtureJSON), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromProtobuf) {
+		t.Errorf("JSON and protobuf decode differently:\njson:     %#v\nprotobuf: %#v", fromJSON, fromProtobuf)
+	}
+}
+
+func TestDecodeOTLPGzip(t *testing.T) {
+	body, err := proto.Marshal(otlpFixture())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		body   []byte
+		isJSON bool
+	}{
+		{"protobuf", body, false},
+		{"json", []byte(otlpFixtureJSON), true},
+	} {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(tt.body)
+		writer.Close()
+
+		records, err := decodeOTLP(compressed.Bytes(), tt.isJSON, true)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(records) != 3 || records[0].Body != "payment declined\nstack trace follows" {
+			t.Errorf("%s: %d records", tt.name, len(records))
+		}
+	}
+
+	if _, err := decodeOTLP(body, false, true); err == nil {
+		t.Error("body that is not gzip was accepted")
+	}
+}
+
+func TestDecodeOTLPInvalid(t *testing.T) {
+	if _, err := decodeOTLP([]byte{0x0a, 0xff}, false, false); err == nil {
+		t.Error("truncated protobuf was accepted")
+	}
+	if _, err := decodeOTLP([]byte(`{"resourceLogs": [`), true, false); err == nil {
+		t.Error("truncated JSON was accepted")
+	}
+}
+
+func TestOTLPRecordToLog(t *testing.T) {
+	body, err := proto.Marshal(otlpFixture())
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := decodeOTLP(body, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logx, err := records[0].ToLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logx.Title != "payment declined" || logx.App != "checkout" || logx.Slug != "error" {
+		t.Errorf("title %q, app %q, slug %q", logx.Title, logx.App, logx.Slug)
+	}
+	if !strings.Contains(logx.Metadata, `"traceId":"5b8efff798038103d269b633813fc60c"`) {
+		t.Errorf("metadata %s has no trace id", logx.Metadata)
+	}
+
+	// without severity text the number picks the slug
+	logx, err = records[1].ToLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logx.Slug != "warn" || logx.Description != `{"event":"slow query"}` {
+		t.Errorf("slug %q, description %q", logx.Slug, logx.Description)
+	}
+
+	long := otlpRecord{Body: strings.Repeat("a", 199) + "é"}
+	logx, err = long.ToLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logx.Title != strings.Repeat("a", 199) {
+		t.Errorf("title is %d bytes, want the cut before the split character", len(logx.Title))
+	}
+}
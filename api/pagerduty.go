@@ -0,0 +1,155 @@
+package api
+
+import (
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/utils"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// PagerDutyEvent is the PagerDuty Events API v2 payload
+type PagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key"`
+	Client      string           `json:"client"`
+	ClientURL   string           `json:"client_url"`
+	Payload     PagerDutyPayload `json:"payload"`
+}
+
+type PagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Timestamp     string                 `json:"timestamp"`
+	Component     string                 `json:"component"`
+	Group         string                 `json:"group"`
+	Class         string                 `json:"class"`
+	CustomDetails map[string]interface{} `json:"custom_details"`
+}
+
+// pagerDutySeverities maps PagerDuty severities onto incident severities
+var pagerDutySeverities = map[string]incidents.Severity{
+	"critical": incidents.SeverityHigh,
+	"error":    incidents.SeverityHigh,
+	"warning":  incidents.SeverityMedium,
+	"info":     incidents.SeverityLow,
+}
+
+// ToEvent validates a PagerDuty event the way the Events API does and maps
+// it onto an ingestion event
+func (e PagerDutyEvent) ToEvent() (Event, []string) {
+	var problems []string
+	event := Event{
+		EventType: EventType(e.EventAction),
+		DedupKey:  e.DedupKey,
+	}
+
+	switch event.EventType {
+	case EventTrigger:
+	case EventAcknowledge, EventResolve:
+		if e.DedupKey == "" {
+			problems = append(problems, "'dedup_key' is missing or blank")
+		}
+		return event, problems
+	default:
+		problems = append(problems, "'event_action' is invalid (must be one of: trigger, acknowledge, resolve)")
+		return event, problems
+	}
+
+	if e.Payload.Summary == "" {
+		problems = append(problems, "'payload.summary' is missing or blank")
+	}
+	if e.Payload.Source == "" {
+		problems = append(problems, "'payload.source' is missing or blank")
+	}
+	severity, ok := pagerDutySeverities[e.Payload.Severity]
+	if !ok {
+		problems = append(problems, "'payload.severity' is invalid (must be one of: critical, error, warning, info)")
+	}
+
+	var description []string
+	description = append(description, "Source: "+e.Payload.Source)
+	for _, field := range []struct{ name, value string }{
+		{"Component", e.Payload.Component},
+		{"Group", e.Payload.Group},
+		{"Class", e.Payload.Class},
+		{"Client", e.Client},
+		{"Link", e.ClientURL},
+	} {
+		if field.value != "" {
+			description = append(description, fmt.Sprintf("%s: %s", field.name, field.value))
+		}
+	}
+
+	event.Title = e.Payload.Summary
+	event.Description = strings.Join(description, "\n")
+	event.Severity = severity
+	event.Source = e.Payload.Source
+	event.Details = e.Payload.CustomDetails
+	return event, problems
+}
+
+// EnqueuePagerDutyEvent accepts events in the PagerDuty Events API v2 shape
+// and answers like PagerDuty does, so existing integrations can send to
+// IAOS unchanged.
+func EnqueuePagerDutyEvent(c *fiber.Ctx) error {
+	teamId := c.Locals("teamId").(string)
+
+	var team auth.Team
+	err := database.FindOne("teams", bson.M{"teamId": teamId}).Decode(&team)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Invalid routing key"})
+	}
+
+	var body PagerDutyEvent
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "invalid event",
+			"message": "Event object is invalid",
+			"errors":  []string{err.Error()},
+		})
+	}
+
+	event, problems := body.ToEvent()
+	if len(problems) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "invalid event",
+			"message": "Event object is invalid",
+			"errors":  problems,
+		})
+	}
+
+	event.Integration, _ = c.Locals("integrationId").(string)
+
+	// PagerDuty hands out a key for triggers without one so they can be
+	// resolved later
+	if event.DedupKey == "" {
+		event.DedupKey, err = utils.GenerateRandomCode(32)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+
+	_, _, err = Ingest(team, event)
+	if err != nil {
+		log.Println(err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status":    "success",
+		"message":   "Event processed",
+		"dedup_key": event.DedupKey,
+	})
+}
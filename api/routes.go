@@ -10,4 +10,21 @@ func RegisterRoutes(app *fiber.App) {
 	api := app.Group("/api/v1").Use(middleware.VerifyAPI())
 	api.Post("/incident", CreateIncident)
 	api.Post("/log", CreateLog)
+	api.Post("/logs", CreateLogs)
+	api.Post("/otlp/v1/logs", ReceiveOTLPLogs)
+	api.Post("/alertmanager", ReceiveAlertmanager)
+
+	// PagerDuty Events API v2 compatible, authenticated by routing_key
+	app.Post("/v2/enqueue", middleware.VerifyRoutingKey(), EnqueuePagerDutyEvent)
+
+	grouping := app.Group("/grouping").Use(middleware.AuthMiddleware())
+	grouping.Get("/", GetGrouping)
+	grouping.Put("/", UpdateGrouping)
+
+	logRules := app.Group("/logrules").Use(middleware.AuthMiddleware())
+	logRules.Post("/", CreateLogRule)
+	logRules.Get("/", GetLogRules)
+	logRules.Get("/:id", GetLogRule)
+	logRules.Put("/:id", UpdateLogRule)
+	logRules.Delete("/:id", DeleteLogRule)
 }
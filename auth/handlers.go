@@ -130,6 +130,40 @@ func UpdateTeam(c *fiber.Ctx) error {
 	})
 }
 
+// UpdateHandoff controls whether open incidents follow the pager to the
+// incoming on-call engineer at each shift handoff
+func UpdateHandoff(c *fiber.Ctx) error {
+	var body struct {
+		Reassign bool `json:"reassign"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	email := c.Locals("email").(string)
+	var user User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter := bson.M{"teamId": user.TeamId}
+	update := bson.M{"$set": bson.M{"handoffReassign": body.Reassign}}
+
+	var team Team
+	err = database.FindOneAndUpdate("teams", filter, update).Decode(&team)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "team data updated",
+		"team":    &team,
+	})
+}
+
 func JoinTeam(c *fiber.Ctx) error {
 	var body JoinTeamBody
 	if err := c.BodyParser(&body); err != nil {
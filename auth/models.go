@@ -44,11 +44,12 @@ const (
 )
 
 type Team struct {
-	ID            primitive.ObjectID `bson:"_id,omitempty"`
-	TeamName      string             `bson:"teamName"`
-	TeamId        string             `bson:"teamId"`
-	Notifications []Notification     `bson:"notifications"`
-	APIKey        string             `json:"apiKey"`
+	ID              primitive.ObjectID `bson:"_id,omitempty"`
+	TeamName        string             `bson:"teamName"`
+	TeamId          string             `bson:"teamId"`
+	Notifications   []Notification     `bson:"notifications"`
+	APIKey          string             `json:"apiKey"`
+	HandoffReassign bool               `bson:"handoffReassign"`
 }
 
 type Notification struct {
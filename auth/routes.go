@@ -15,5 +15,6 @@ func RegisterAuthRoutes(app *fiber.App) {
 
 	teamRoutes := app.Group("/team").Use(middleware.AuthMiddleware())
 	teamRoutes.Put("/", UpdateTeam)
+	teamRoutes.Put("/handoff", UpdateHandoff)
 
 }
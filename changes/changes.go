@@ -0,0 +1,49 @@
+package changes
+
+import (
+	"context"
+	"issue-reporting/database"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultLookback is how far before an incident changes are looked for,
+// CHANGE_LOOKBACK_MINUTES or two hours
+func DefaultLookback() time.Duration {
+	if value, err := strconv.Atoi(os.Getenv("CHANGE_LOOKBACK_MINUTES")); err == nil && value > 0 {
+		return time.Duration(value) * time.Minute
+	}
+	return 2 * time.Hour
+}
+
+// Before lists the team's changes to service in the lookback before at,
+// newest first. Services match regardless of case, an empty service matches
+// changes to any service.
+func Before(teamId string, service string, at time.Time, lookback time.Duration) ([]Change, error) {
+	ctx := context.Background()
+	filter := bson.M{
+		"teamid":    teamId,
+		"timestamp": bson.M{"$gte": at.Add(-lookback), "$lte": at},
+	}
+	if service != "" {
+		filter["service"] = bson.M{"$regex": "^" + regexp.QuoteMeta(service) + "$", "$options": "i"}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	cursor, err := database.GetDatabase().Database("IssueReporting").Collection("changes").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	changes := []Change{}
+	if err := cursor.All(ctx, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
@@ -0,0 +1,53 @@
+package changes
+
+import (
+	"context"
+	"issue-reporting/database"
+	"issue-reporting/utils"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBeforeMatchesServiceIgnoringCase(t *testing.T) {
+	if os.Getenv("MONGODB") == "" {
+		t.Skip("MONGODB is not set")
+	}
+	if err := database.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	id, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	teamId := "test-" + id
+	t.Cleanup(func() {
+		database.GetDatabase().Database("IssueReporting").Collection("changes").DeleteMany(context.Background(), bson.M{"teamid": teamId})
+	})
+
+	now := time.Now()
+	for i, service := range []string{"Checkout", "checkout-worker", "payments"} {
+		change := Change{Id: service, TeamId: teamId, Type: TypeDeploy, Service: service, Timestamp: now.Add(-time.Duration(i+1) * time.Minute)}
+		if _, err := database.InsertOne("changes", change); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, err := Before(teamId, "checkout", now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Service != "Checkout" {
+		t.Errorf("found %v, want only the Checkout change", found)
+	}
+
+	all, err := Before(teamId, "", now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("%d changes for any service, want 3", len(all))
+	}
+}
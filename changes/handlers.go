@@ -0,0 +1,129 @@
+package changes
+
+import (
+	"errors"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/utils"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CreateChange records a change event sent with the team API key, e.g. by
+// a deploy pipeline
+func CreateChange(c *fiber.Ctx) error {
+	teamId := c.Locals("teamId").(string)
+
+	var team auth.Team
+	err := database.FindOne("teams", bson.M{"teamId": teamId}).Decode(&team)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Invalid API key"})
+	}
+
+	var change Change
+	if err := c.BodyParser(&change); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	if change.Type == "" {
+		change.Type = TypeDeploy
+	}
+	if change.Timestamp.IsZero() {
+		change.Timestamp = time.Now()
+	}
+	if err := VerifyChange(change); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	change.Id = code
+	change.TeamId = team.TeamId
+	change.CreatedAt = time.Now()
+
+	_, err = database.InsertOne("changes", change)
+	if err != nil {
+		log.Println(err)
+		return c.Status(fiber.StatusExpectationFailed).JSON(fiber.Map{
+			"message": "change not created",
+			"status":  false,
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "change created",
+		"change":  change.Id,
+	})
+}
+
+// GetChanges lists the team's changes in the last lookback minutes
+// (default the incident lookback), optionally for one service
+func GetChanges(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	lookback, err := Lookback(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	changes, err := Before(user.TeamId, c.Query("service"), time.Now(), lookback)
+	if err != nil {
+		return fmt.Errorf("error finding changes: %v", err)
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "changes data",
+		"changes": changes,
+	})
+}
+
+// Lookback reads the lookback query in minutes, falling back to
+// DefaultLookback
+func Lookback(c *fiber.Ctx) (time.Duration, error) {
+	if c.Query("lookback") == "" {
+		return DefaultLookback(), nil
+	}
+	minutes := c.QueryInt("lookback")
+	if minutes <= 0 {
+		return 0, errors.New("lookback must be a positive number of minutes")
+	}
+	return time.Duration(minutes) * time.Minute, nil
+}
+
+func VerifyChange(change Change) error {
+	if change.Summary == "" {
+		return errors.New("summary is required")
+	}
+	switch change.Type {
+	case TypeDeploy, TypeConfig, TypeFeatureFlag, TypeOther:
+	default:
+		return fmt.Errorf("type must be one of %s, %s, %s or %s", TypeDeploy, TypeConfig, TypeFeatureFlag, TypeOther)
+	}
+	for i, link := range change.Links {
+		if link.Href == "" {
+			return fmt.Errorf("link %d: href is required", i+1)
+		}
+	}
+	return nil
+}
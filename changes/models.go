@@ -0,0 +1,33 @@
+package changes
+
+import "time"
+
+// Change is something that was done to a service, such as a deploy, a
+// config change or a feature flag flip. Changes shortly before an incident
+// are shown with it.
+type Change struct {
+	Id        string                 `json:"id"`
+	TeamId    string                 `json:"teamId"`
+	Type      Type                   `json:"type"`
+	Summary   string                 `json:"summary"`
+	Source    string                 `json:"source"`
+	Service   string                 `json:"service"`
+	Timestamp time.Time              `json:"timestamp"`
+	Links     []Link                 `json:"links"`
+	Details   map[string]interface{} `json:"details"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+type Link struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+type Type string
+
+const (
+	TypeDeploy      Type = "deploy"
+	TypeConfig      Type = "config"
+	TypeFeatureFlag Type = "feature_flag"
+	TypeOther       Type = "other"
+)
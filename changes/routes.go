@@ -0,0 +1,15 @@
+package changes
+
+import (
+	"issue-reporting/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterRoutes(app *fiber.App) {
+	changes := app.Group("/changes").Use(middleware.AuthMiddleware())
+	changes.Get("/", GetChanges)
+
+	// sent by deploy pipelines and flag services with the team API key
+	app.Post("/api/v1/changes", middleware.VerifyAPI(), CreateChange)
+}
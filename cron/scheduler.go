@@ -2,43 +2,29 @@ package cron
 
 import (
 	"context"
-	"fmt"
+	"issue-reporting/api"
 	"issue-reporting/database"
+	"issue-reporting/escalations"
+	"issue-reporting/handoffs"
+	"issue-reporting/heartbeats"
 	"issue-reporting/incidents"
-	"issue-reporting/notification"
 	"issue-reporting/reports"
 	"issue-reporting/schedules"
 	"log"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/robfig/cron/v3"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func StartNotifyAcknowlegedScheduler() {
+func StartEscalationScheduler() {
 	c := cron.New()
 
-	_, err := c.AddFunc("@every 10m", func() {
-		cursor, err := incidents.List()
-		if err != nil {
-			log.Printf("Error starting cronjob: %v", err)
-		}
-		if cursor == nil {
-			log.Printf("Error starting cronjob: %v", err)
-		}
-
-		for _, incident := range cursor {
-			for _, user := range incident.AssignedTo {
-				var assignee string
-				if user.Name != "" {
-					assignee = fmt.Sprintf("%s @%s", user.Name, user.SlackHandle)
-				} else {
-					assignee = "Unassigned"
-				}
-
-				notification.SendNotification(fmt.Sprintf("This incident has not been acknowledged yet. \nPlease acknowledge them otherwise you will be reminded every 10 minutes: \n\n[%s] \n\n[#%s] %s", assignee, incident.Id, incident.Title), user)
-			}
-		}
+	_, err := c.AddFunc("@every 1m", func() {
+		escalations.Escalate()
 	})
 	if err != nil {
 		log.Printf("Error adding cronjob: %v", err)
@@ -57,9 +43,19 @@ func StartNotifyAssignScheduler() {
 		}
 
 		for _, incident := range cursor {
-			schedule, err := schedules.ScheduledNow(incident.TeamId)
+			if incident.Suppressed || incident.Flapping {
+				// raised during maintenance, or held open for a flapping
+				// alert whose page already went out, nobody is to be bothered
+				continue
+			}
+			schedule, err := schedules.Responder(time.Now(), incident.TeamId)
 			if err != nil {
 				log.Printf("Error starting cronjob1: %v", err)
+				continue
+			}
+			if schedule == nil {
+				// nobody on call, the coverage gap alerts cover this
+				continue
 			}
 			if incident.AssignedTo == nil || len(incident.AssignedTo) == 0 {
 				_, err := incidents.Assign(incident.Id, &incidents.AssignParams{User: schedule.User})
@@ -80,6 +76,77 @@ func StartNotifyAssignScheduler() {
 	c.Start()
 }
 
+func StartCoverageGapScheduler() {
+	days := 7
+	if daysStr := os.Getenv("COVERAGE_ALERT_DAYS"); daysStr != "" {
+		if parsed, err := strconv.Atoi(daysStr); err == nil && parsed > 0 {
+			days = parsed
+		}
+	}
+
+	c := cron.New()
+	_, err := c.AddFunc("@every 1h", func() {
+		schedules.AlertCoverageGaps(days)
+	})
+	if err != nil {
+		log.Printf("Error adding cronjob: %v", err)
+	}
+
+	c.Start()
+}
+
+func StartHandoffScheduler() {
+	lastRun := time.Now()
+
+	c := cron.New()
+	_, err := c.AddFunc("@every 1m", func() {
+		now := time.Now()
+		handoffs.Run(lastRun, now)
+		lastRun = now
+	})
+	if err != nil {
+		log.Printf("Error adding cronjob: %v", err)
+	}
+
+	c.Start()
+}
+
+func StartHeartbeatScheduler() {
+	c := cron.New()
+	_, err := c.AddFunc("@every 1m", func() {
+		heartbeats.Check(time.Now())
+	})
+	if err != nil {
+		log.Printf("Error adding cronjob: %v", err)
+	}
+
+	c.Start()
+}
+
+func StartFlappingScheduler() {
+	c := cron.New()
+	_, err := c.AddFunc("@every 1m", func() {
+		api.SettleFlapping(time.Now())
+	})
+	if err != nil {
+		log.Printf("Error adding cronjob: %v", err)
+	}
+
+	c.Start()
+}
+
+func StartMaintenanceScheduler() {
+	c := cron.New()
+	_, err := c.AddFunc("@every 1m", func() {
+		api.ReleaseEndedMaintenance(time.Now())
+	})
+	if err != nil {
+		log.Printf("Error adding cronjob: %v", err)
+	}
+
+	c.Start()
+}
+
 func ReportGeneratorScheduler() {
 	c := cron.New()
 	_, err := c.AddFunc("@every 30m", func() {
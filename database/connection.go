@@ -101,3 +101,13 @@ func FindOneAndUpdate(collectionName string, filter interface{}, update interfac
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 	return collection.FindOneAndUpdate(ctx, filter, update, opts)
 }
+
+// UpdateMany updates all documents in the specified collection that match the filter
+func UpdateMany(collectionName string, filter interface{}, update interface{}) (*mongo.UpdateResult, error) {
+	collection := Client.Database(dbName).Collection(collectionName)
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
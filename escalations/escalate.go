@@ -0,0 +1,246 @@
+package escalations
+
+import (
+	"encoding/json"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/notification"
+	"issue-reporting/schedules"
+	"issue-reporting/users"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Escalate walks every unacknowledged incident one step up its escalation
+// policy when the current level has timed out.
+func Escalate() {
+	list, err := incidents.List()
+	if err != nil {
+		log.Printf("Error listing incidents for escalation: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, incident := range list {
+		// flapping alerts get one page until they settle
+		if incident.Resolved || incident.Suppressed || incident.Flapping {
+			continue
+		}
+
+		policy, err := PolicyFor(incident)
+		if err != nil {
+			log.Printf("Error finding escalation policy for incident %s: %v", incident.Id, err)
+			continue
+		}
+		if policy == nil {
+			continue
+		}
+
+		level, repeats, due := nextStep(incident, *policy, now)
+		if !due {
+			continue
+		}
+
+		if err := escalateTo(incident, *policy, level, repeats, now); err != nil {
+			log.Printf("Error escalating incident %s: %v", incident.Id, err)
+		}
+	}
+}
+
+// PolicyFor returns the policy set on the incident, falling back to the
+// team's default policy and then to the built-in primary/secondary policy.
+func PolicyFor(incident incidents.Incident) (*Policy, error) {
+	filter := bson.M{"teamid": incident.TeamId, "default": true}
+	if incident.EscalationPolicy != "" {
+		filter = bson.M{"teamid": incident.TeamId, "id": incident.EscalationPolicy}
+	}
+
+	var policy Policy
+	err := database.FindOne("escalationpolicies", filter).Decode(&policy)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			if incident.EscalationPolicy != "" {
+				return nil, nil
+			}
+			fallback := fallbackPolicy
+			return &fallback, nil
+		}
+		return nil, err
+	}
+	if len(policy.Levels) == 0 {
+		return nil, nil
+	}
+	return &policy, nil
+}
+
+// nextStep works out which level should be paged next and whether it is
+// due yet. The first level is paged as soon as the incident is picked up.
+func nextStep(incident incidents.Incident, policy Policy, now time.Time) (int, int, bool) {
+	if incident.EscalatedAt.IsZero() {
+		return 0, 0, true
+	}
+
+	current := incident.EscalationLevel
+	if current >= len(policy.Levels) {
+		current = len(policy.Levels) - 1
+	}
+	wait := time.Duration(policy.Levels[current].EscalateAfter) * time.Minute
+	if now.Sub(incident.EscalatedAt) < wait {
+		return 0, 0, false
+	}
+
+	next := current + 1
+	repeats := incident.EscalationRepeats
+	if next >= len(policy.Levels) {
+		if repeats >= policy.Repeat {
+			return 0, 0, false
+		}
+		next = 0
+		repeats++
+	}
+	return next, repeats, true
+}
+
+func escalateTo(incident incidents.Incident, policy Policy, level int, repeats int, now time.Time) error {
+	targets, err := ResolveTargets(incident.TeamId, policy.Levels[level].Targets, now)
+	if err != nil {
+		return err
+	}
+
+	// the on-call engineer was paged when the incident was created, so the
+	// very first step only reaches people who have not heard about it yet
+	firstStep := incident.EscalatedAt.IsZero()
+
+	var names []string
+	var paged, assignees []auth.User
+	for _, user := range targets {
+		names = append(names, user.Name)
+		if !isAssigned(incident, user) {
+			assignees = append(assignees, user)
+		} else if firstStep {
+			continue
+		}
+		paged = append(paged, user)
+	}
+
+	data := map[string]interface{}{
+		"policy":  policy.Name,
+		"level":   level + 1,
+		"repeat":  repeats,
+		"subtext": fmt.Sprintf("Escalated to level %d: %s", level+1, strings.Join(names, ", ")),
+	}
+	if len(names) == 0 {
+		data["subtext"] = fmt.Sprintf("Escalated to level %d: nobody to page", level+1)
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+	}
+
+	timepoint := incidents.Timepoint{
+		Title:     "Escalated ⏫",
+		CreatedAt: now,
+		Metadata:  string(jsonData),
+	}
+
+	filter := bson.M{"id": incident.Id, "acknowledged": false, "resolved": false}
+	update := bson.M{
+		"$set": bson.M{
+			"escalationpolicy":  policy.Id,
+			"escalationlevel":   level,
+			"escalationrepeats": repeats,
+			"escalatedat":       now,
+		},
+		"$push": bson.M{"timeline": timepoint},
+	}
+	if len(assignees) > 0 {
+		update["$push"] = bson.M{"timeline": timepoint, "assignedto": bson.M{"$each": assignees}}
+	}
+
+	result, err := database.UpdateOne("incidents", filter, update)
+	if err != nil {
+		return err
+	}
+	// acknowledged or resolved since it was listed, so nobody gets paged
+	if result.MatchedCount == 0 {
+		return nil
+	}
+
+	for _, user := range paged {
+		notification.SendPage(fmt.Sprintf("Escalated (level %d): \nIncident #%s has not been acknowledged\nTitle: %s\nDescription: %s\nSeverity: %s", level+1, incident.Id, incident.Title, incident.Description, incident.Severity), user, incident.Id)
+	}
+
+	return nil
+}
+
+// ResolveTargets expands policy targets into the users they point at right now.
+func ResolveTargets(teamId string, targets []Target, at time.Time) ([]auth.User, error) {
+	var resolved []auth.User
+	seen := map[string]bool{}
+	add := func(user auth.User) {
+		if user.Email == "" || seen[user.Email] {
+			return
+		}
+		seen[user.Email] = true
+		resolved = append(resolved, user)
+	}
+
+	for _, target := range targets {
+		switch target.Type {
+		case TargetUser:
+			var user auth.User
+			err := database.FindOne("users", bson.M{"code": target.Id, "teamId": teamId}).Decode(&user)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					log.Println("escalation target user not found", target.Id)
+					continue
+				}
+				return nil, err
+			}
+			add(user)
+		case TargetSchedule:
+			schedule, err := schedules.ScheduledLayer(at, teamId, target.Id, target.Layer)
+			if err != nil {
+				return nil, err
+			}
+			if schedule == nil {
+				continue
+			}
+			var user auth.User
+			err = database.FindOne("users", bson.M{"email": schedule.User.Email}).Decode(&user)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					continue
+				}
+				return nil, err
+			}
+			add(user)
+		case TargetRole:
+			members, err := users.WithRoles(teamId, auth.Role(target.Id))
+			if err != nil {
+				return nil, err
+			}
+			for _, user := range members {
+				add(user)
+			}
+		}
+	}
+
+	return resolved, nil
+}
+
+func isAssigned(incident incidents.Incident, user auth.User) bool {
+	for _, assigned := range incident.AssignedTo {
+		if assigned.Email == user.Email {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+package escalations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/schedules"
+	"issue-reporting/utils"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func CreatePolicy(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var policy Policy
+	if err := c.BodyParser(&policy); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if err := VerifyPolicy(policy); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	policy.Id = code
+	policy.TeamId = user.TeamId
+	policy.CreatedAt = time.Now()
+	policy.UpdatedAt = time.Now()
+
+	if policy.Default {
+		if err := clearDefault(user.TeamId); err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+		}
+	}
+
+	_, err = database.InsertOne("escalationpolicies", policy)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "escalation policy not created")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "escalation policy created",
+		"policy":  policy.Id,
+	})
+}
+
+func GetPolicies(c *fiber.Ctx) error {
+	ctx := context.Background()
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	cursor, err := database.Find("escalationpolicies", bson.M{"teamid": user.TeamId})
+	if err != nil {
+		return fmt.Errorf("error finding escalation policies: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var policies []Policy
+	if err := cursor.All(ctx, &policies); err != nil {
+		return fmt.Errorf("error decoding escalation policies: %v", err)
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":  "escalation policies data",
+		"policies": policies,
+	})
+}
+
+func GetPolicy(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var policy Policy
+	err = database.FindOne("escalationpolicies", bson.M{"id": c.Params("id"), "teamid": user.TeamId}).Decode(&policy)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusNotFound, "No escalation policy found")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "escalation policy data",
+		"policy":  &policy,
+	})
+}
+
+func UpdatePolicy(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var body Policy
+	if err := c.BodyParser(&body); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if err := VerifyPolicy(body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	if body.Default {
+		if err := clearDefault(user.TeamId); err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+		}
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+	update := bson.M{"$set": bson.M{
+		"name":      body.Name,
+		"default":   body.Default,
+		"levels":    body.Levels,
+		"repeat":    body.Repeat,
+		"updatedat": time.Now(),
+	}}
+
+	var policy Policy
+	err = database.FindOneAndUpdate("escalationpolicies", filter, update).Decode(&policy)
+	if err != nil {
+		return fiber.NewError(fiber.StatusNoContent, err.Error())
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "escalation policy updated",
+		"policy":  &policy,
+	})
+}
+
+func DeletePolicy(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+
+	var policy Policy
+	err = database.FindOne("escalationpolicies", filter).Decode(&policy)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusExpectationFailed, "No escalation policy found")
+	}
+
+	_, err = database.InsertOne("deletedescalationpolicies", policy)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	_, err = database.DeleteOne("escalationpolicies", filter)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "escalation policy deleted",
+		"policy":  &policy,
+	})
+}
+
+func VerifyPolicy(policy Policy) error {
+	if policy.Name == "" {
+		return errors.New("policy name is required")
+	}
+
+	if len(policy.Levels) == 0 {
+		return errors.New("policy needs at least one level")
+	}
+
+	if policy.Repeat < 0 {
+		return errors.New("repeat cannot be negative")
+	}
+
+	for i, level := range policy.Levels {
+		if level.EscalateAfter <= 0 {
+			return fmt.Errorf("level %d: escalateAfter must be greater than zero", i+1)
+		}
+		if len(level.Targets) == 0 {
+			return fmt.Errorf("level %d: at least one target is required", i+1)
+		}
+		for _, target := range level.Targets {
+			switch target.Type {
+			case TargetUser, TargetRole:
+				if target.Id == "" {
+					return fmt.Errorf("level %d: %s target needs an id", i+1, target.Type)
+				}
+			case TargetSchedule:
+				if _, err := schedules.VerifyLayer(target.Layer); err != nil {
+					return fmt.Errorf("level %d: %v", i+1, err)
+				}
+			default:
+				return fmt.Errorf("level %d: unknown target type %q", i+1, target.Type)
+			}
+		}
+	}
+
+	return nil
+}
+
+func clearDefault(teamId string) error {
+	_, err := database.UpdateMany("escalationpolicies", bson.M{"teamid": teamId}, bson.M{"$set": bson.M{"default": false}})
+	return err
+}
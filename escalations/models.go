@@ -0,0 +1,53 @@
+package escalations
+
+import (
+	"issue-reporting/schedules"
+	"time"
+)
+
+type Policy struct {
+	Id        string    `json:"id"`
+	TeamId    string    `json:"teamId"`
+	Name      string    `json:"name"`
+	Default   bool      `json:"default"`
+	Levels    []Level   `json:"levels"`
+	Repeat    int       `json:"repeat"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Level is one step of a policy. Its targets are paged together and the
+// incident moves on to the next level once EscalateAfter minutes pass
+// without an acknowledgement.
+type Level struct {
+	EscalateAfter int      `json:"escalateAfter"`
+	Targets       []Target `json:"targets"`
+}
+
+// Target points a level at a user (Id is the user code), a role (Id is the
+// role name) or a schedule (Id is the schedule name, empty for any, and
+// Layer picks primary, secondary or shadow).
+type Target struct {
+	Type  TargetType      `json:"type"`
+	Id    string          `json:"id"`
+	Layer schedules.Layer `json:"layer"`
+}
+
+type TargetType string
+
+const (
+	TargetUser     TargetType = "User"
+	TargetSchedule TargetType = "Schedule"
+	TargetRole     TargetType = "Role"
+)
+
+// fallbackPolicy applies to teams without an escalation policy of their
+// own: the primary on-call is paged first and, if nobody acknowledges,
+// the incident falls through to the secondary layer.
+var fallbackPolicy = Policy{
+	Name: "Primary then secondary",
+	Levels: []Level{
+		{EscalateAfter: 15, Targets: []Target{{Type: TargetSchedule, Layer: schedules.Primary}}},
+		{EscalateAfter: 15, Targets: []Target{{Type: TargetSchedule, Layer: schedules.Secondary}}},
+	},
+}
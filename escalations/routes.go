@@ -0,0 +1,16 @@
+package escalations
+
+import (
+	"issue-reporting/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterRoutes(app *fiber.App) {
+	escalations := app.Group("/escalations").Use(middleware.AuthMiddleware())
+	escalations.Post("/", CreatePolicy)
+	escalations.Get("/", GetPolicies)
+	escalations.Get("/:id", GetPolicy)
+	escalations.Put("/:id", UpdatePolicy)
+	escalations.Delete("/:id", DeletePolicy)
+}
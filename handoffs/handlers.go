@@ -0,0 +1,38 @@
+package handoffs
+
+import (
+	"context"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func GetHandoffs(c *fiber.Ctx) error {
+	ctx := context.Background()
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}}).SetLimit(50)
+	cursor, err := database.GetDatabase().Database("IssueReporting").Collection("handoffs").Find(ctx, bson.M{"teamid": user.TeamId}, opts)
+	if err != nil {
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting handoffs")
+	}
+	defer cursor.Close(ctx)
+
+	var handoffs []Handoff
+	if err := cursor.All(ctx, &handoffs); err != nil {
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting handoffs")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":  "handoffs data",
+		"handoffs": handoffs,
+	})
+}
@@ -0,0 +1,212 @@
+package handoffs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/notification"
+	"issue-reporting/schedules"
+	"issue-reporting/utils"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// recentlyResolved is how far back the summary looks for resolved incidents
+const recentlyResolved = 24 * time.Hour
+
+// Run looks for primary on-call changes between from and to for every team
+// and hands each one over.
+func Run(from, to time.Time) {
+	ctx := context.Background()
+	cursor, err := database.Find("teams", bson.M{})
+	if err != nil {
+		log.Printf("Error finding teams: %v", err)
+		return
+	}
+	var teams []auth.Team
+	if err := cursor.All(ctx, &teams); err != nil {
+		log.Printf("Error decoding teams: %v", err)
+		return
+	}
+
+	for _, team := range teams {
+		if err := handoffTeam(team, from, to); err != nil {
+			log.Printf("Error handing off team %s: %v", team.TeamId, err)
+		}
+	}
+}
+
+func handoffTeam(team auth.Team, from, to time.Time) error {
+	before, err := schedules.Scheduled(from, team.TeamId)
+	if err != nil {
+		return err
+	}
+	after, err := schedules.Scheduled(to, team.TeamId)
+	if err != nil {
+		return err
+	}
+	if after == nil || (before != nil && before.User.Email == after.User.Email) {
+		return nil
+	}
+
+	at := to
+	if after.Time.Start.After(from) && !after.Time.Start.After(to) {
+		at = after.Time.Start
+	}
+
+	// the job may run more than once over the same boundary
+	existing := bson.M{"teamid": team.TeamId, "incoming.email": after.User.Email, "at": at}
+	if err := database.FindOne("handoffs", existing).Err(); err == nil {
+		return nil
+	}
+
+	handoff := Handoff{
+		TeamId:    team.TeamId,
+		Incoming:  after.User,
+		At:        at,
+		CreatedAt: time.Now(),
+	}
+	if before != nil {
+		handoff.Outgoing = before.User
+	}
+
+	open, err := teamIncidents(bson.M{"teamid": team.TeamId, "resolved": false})
+	if err != nil {
+		return err
+	}
+	resolved, err := teamIncidents(bson.M{"teamid": team.TeamId, "resolved": true, "resolvedat": bson.M{"$gte": to.Add(-recentlyResolved)}})
+	if err != nil {
+		return err
+	}
+
+	handoff.Summary.Open = len(open)
+	handoff.Summary.Resolved = len(resolved)
+	for _, incident := range open {
+		if !incident.Acknowledged {
+			handoff.Summary.Unacknowledged++
+		}
+	}
+
+	if team.HandoffReassign && before != nil {
+		for _, incident := range open {
+			if reassign(incident, before.User, after.User) {
+				handoff.Reassigned = append(handoff.Reassigned, incident.Id)
+			}
+		}
+	}
+
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		return err
+	}
+	handoff.Id = code
+
+	_, err = database.InsertOne("handoffs", handoff)
+	if err != nil {
+		return err
+	}
+
+	if before != nil {
+		notification.SendNotification(fmt.Sprintf("Your on-call shift has ended. %s is now on call.\n%d open incidents were handed over.", after.User.Name, handoff.Summary.Open), before.User)
+	}
+	notification.SendNotification(summaryMessage(handoff, open, resolved), after.User)
+
+	return nil
+}
+
+func teamIncidents(filter bson.M) ([]incidents.Incident, error) {
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "createdat", Value: -1}})
+	cursor, err := database.GetDatabase().Database("IssueReporting").Collection("incidents").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var list []incidents.Incident
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// reassign moves an open incident from the outgoing to the incoming
+// engineer, recording it on the timeline
+func reassign(incident incidents.Incident, outgoing, incoming auth.User) bool {
+	var assigned []auth.User
+	found := false
+	for _, user := range incident.AssignedTo {
+		if user.Email == outgoing.Email {
+			found = true
+			continue
+		}
+		if user.Email == incoming.Email {
+			continue
+		}
+		assigned = append(assigned, user)
+	}
+	if !found {
+		return false
+	}
+	assigned = append(assigned, incoming)
+
+	data := map[string]interface{}{
+		"assignedTo": incoming.Name,
+		"subtext":    fmt.Sprintf("Handed over from %s to %s at shift change", outgoing.Name, incoming.Name),
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error marshalling JSON:", err)
+	}
+
+	timepoint := incidents.Timepoint{
+		Title:     "Incident Reassigned",
+		CreatedAt: time.Now(),
+		Metadata:  string(jsonData),
+	}
+
+	update := bson.M{"$set": bson.M{"assignedto": assigned}, "$push": bson.M{"timeline": timepoint}}
+	_, err = database.UpdateOne("incidents", bson.M{"id": incident.Id}, update)
+	if err != nil {
+		log.Printf("Error reassigning incident %s: %v", incident.Id, err)
+		return false
+	}
+	return true
+}
+
+func summaryMessage(handoff Handoff, open, resolved []incidents.Incident) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "You are now on call.\n\n")
+	fmt.Fprintf(&b, "Open: %d, unacknowledged: %d, resolved in the last 24h: %d\n", handoff.Summary.Open, handoff.Summary.Unacknowledged, handoff.Summary.Resolved)
+
+	if len(open) > 0 {
+		fmt.Fprintf(&b, "\nStill open:\n")
+		for _, incident := range open {
+			state := "acknowledged"
+			if !incident.Acknowledged {
+				state = "unacknowledged"
+			}
+			fmt.Fprintf(&b, "- #%s [%s] %s (%s)\n", incident.Id, incident.Severity, incident.Title, state)
+		}
+	}
+
+	if len(resolved) > 0 {
+		fmt.Fprintf(&b, "\nRecently resolved:\n")
+		for _, incident := range resolved {
+			fmt.Fprintf(&b, "- #%s %s\n", incident.Id, incident.Title)
+		}
+	}
+
+	if len(handoff.Reassigned) > 0 {
+		fmt.Fprintf(&b, "\nReassigned to you: #%s\n", strings.Join(handoff.Reassigned, ", #"))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,23 @@
+package handoffs
+
+import (
+	"issue-reporting/auth"
+	"time"
+)
+
+type Handoff struct {
+	Id         string    `json:"id"`
+	TeamId     string    `json:"teamId"`
+	Outgoing   auth.User `json:"outgoing"`
+	Incoming   auth.User `json:"incoming"`
+	At         time.Time `json:"at"`
+	Summary    Summary   `json:"summary"`
+	Reassigned []string  `json:"reassigned"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Summary struct {
+	Open           int `json:"open"`
+	Unacknowledged int `json:"unacknowledged"`
+	Resolved       int `json:"resolved"`
+}
@@ -0,0 +1,12 @@
+package handoffs
+
+import (
+	"issue-reporting/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterRoutes(app *fiber.App) {
+	handoffs := app.Group("/handoffs").Use(middleware.AuthMiddleware())
+	handoffs.Get("/", GetHandoffs)
+}
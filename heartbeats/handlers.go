@@ -0,0 +1,252 @@
+package heartbeats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/utils"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func CreateHeartbeat(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var heartbeat Heartbeat
+	if err := c.BodyParser(&heartbeat); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if heartbeat.Severity == "" {
+		heartbeat.Severity = incidents.SeverityHigh
+	}
+	if err := VerifyHeartbeat(heartbeat); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	heartbeat.Id = code
+	heartbeat.TeamId = user.TeamId
+	heartbeat.Status = StatusNew
+	heartbeat.LastPingAt = nil
+	heartbeat.CreatedAt = time.Now()
+	heartbeat.UpdatedAt = time.Now()
+
+	_, err = database.InsertOne("heartbeats", heartbeat)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "heartbeat not created")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":   "heartbeat created",
+		"heartbeat": heartbeat,
+		"url":       "/api/v1/heartbeats/" + heartbeat.Id + "/ping",
+	})
+}
+
+func GetHeartbeats(c *fiber.Ctx) error {
+	ctx := context.Background()
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	cursor, err := database.Find("heartbeats", bson.M{"teamid": user.TeamId})
+	if err != nil {
+		return fmt.Errorf("error finding heartbeats: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var heartbeats []Heartbeat
+	if err := cursor.All(ctx, &heartbeats); err != nil {
+		return fmt.Errorf("error decoding heartbeats: %v", err)
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":    "heartbeats data",
+		"heartbeats": heartbeats,
+	})
+}
+
+func GetHeartbeat(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var heartbeat Heartbeat
+	err = database.FindOne("heartbeats", bson.M{"id": c.Params("id"), "teamid": user.TeamId}).Decode(&heartbeat)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusNotFound, "No heartbeat found")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":   "heartbeat data",
+		"heartbeat": &heartbeat,
+	})
+}
+
+func UpdateHeartbeat(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var body Heartbeat
+	if err := c.BodyParser(&body); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if body.Severity == "" {
+		body.Severity = incidents.SeverityHigh
+	}
+	if err := VerifyHeartbeat(body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+	update := bson.M{"$set": bson.M{
+		"name":        body.Name,
+		"description": body.Description,
+		"interval":    body.Interval,
+		"grace":       body.Grace,
+		"severity":    body.Severity,
+		"paused":      body.Paused,
+		"updatedat":   time.Now(),
+	}}
+
+	var heartbeat Heartbeat
+	err = database.FindOneAndUpdate("heartbeats", filter, update).Decode(&heartbeat)
+	if err != nil {
+		return fiber.NewError(fiber.StatusNoContent, err.Error())
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":   "heartbeat updated",
+		"heartbeat": &heartbeat,
+	})
+}
+
+func DeleteHeartbeat(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+
+	var heartbeat Heartbeat
+	err = database.FindOne("heartbeats", filter).Decode(&heartbeat)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusExpectationFailed, "No heartbeat found")
+	}
+
+	_, err = database.InsertOne("deletedheartbeats", heartbeat)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	_, err = database.DeleteOne("heartbeats", filter)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":   "heartbeat deleted",
+		"heartbeat": &heartbeat,
+	})
+}
+
+// ReceivePing is hit by the monitored job, authenticated by the team key.
+// GET works too so a plain curl at the end of a cron job is enough.
+func ReceivePing(c *fiber.Ctx) error {
+	teamId := c.Locals("teamId").(string)
+
+	var team auth.Team
+	err := database.FindOne("teams", bson.M{"teamId": teamId}).Decode(&team)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Invalid API key"})
+	}
+
+	var heartbeat Heartbeat
+	err = database.FindOne("heartbeats", bson.M{"id": c.Params("id"), "teamid": teamId}).Decode(&heartbeat)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusNotFound, "No heartbeat found")
+	}
+
+	recovered, err := Ping(team, heartbeat, time.Now())
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":   "pong",
+		"recovered": recovered,
+	})
+}
+
+func VerifyHeartbeat(heartbeat Heartbeat) error {
+	if heartbeat.Name == "" {
+		return errors.New("heartbeat name is required")
+	}
+	if heartbeat.Interval <= 0 {
+		return errors.New("interval must be greater than zero")
+	}
+	if heartbeat.Grace < 0 {
+		return errors.New("grace cannot be negative")
+	}
+	switch heartbeat.Severity {
+	case incidents.SeverityLow, incidents.SeverityMedium, incidents.SeverityHigh:
+	default:
+		return errors.New("severity must be Low, Medium or High")
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package heartbeats
+
+import (
+	"context"
+	"fmt"
+	"issue-reporting/api"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Overdue reports whether the heartbeat missed its last expected ping.
+// Heartbeats that were never pinged count from when they were created.
+func (h Heartbeat) Overdue(now time.Time) bool {
+	last := h.CreatedAt
+	if h.LastPingAt != nil {
+		last = *h.LastPingAt
+	}
+	deadline := last.Add(time.Duration(h.Interval+h.Grace) * time.Minute)
+	return now.After(deadline)
+}
+
+// dedupKey ties the incident of a heartbeat to it, so the incident is
+// resolved when pings resume
+func (h Heartbeat) dedupKey() string {
+	return "heartbeat:" + h.Id
+}
+
+// Check opens an incident for every heartbeat that went overdue since the
+// last check
+func Check(now time.Time) {
+	ctx := context.Background()
+	cursor, err := database.Find("heartbeats", bson.M{"paused": false, "status": bson.M{"$ne": StatusDown}})
+	if err != nil {
+		log.Printf("Error listing heartbeats: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var heartbeats []Heartbeat
+	if err := cursor.All(ctx, &heartbeats); err != nil {
+		log.Printf("Error decoding heartbeats: %v", err)
+		return
+	}
+
+	for _, heartbeat := range heartbeats {
+		if !heartbeat.Overdue(now) {
+			continue
+		}
+
+		// a ping landing in between wins, the heartbeat is not down then
+		filter := bson.M{"id": heartbeat.Id, "status": heartbeat.Status, "lastpingat": heartbeat.LastPingAt}
+		result, err := database.UpdateOne("heartbeats", filter, bson.M{"$set": bson.M{"status": StatusDown, "updatedat": now}})
+		if err != nil {
+			log.Printf("Error marking heartbeat %s down: %v", heartbeat.Id, err)
+			continue
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+
+		if err := raise(heartbeat, now); err != nil {
+			log.Printf("Error raising incident for heartbeat %s: %v", heartbeat.Id, err)
+		}
+	}
+}
+
+func raise(heartbeat Heartbeat, now time.Time) error {
+	var team auth.Team
+	if err := database.FindOne("teams", bson.M{"teamId": heartbeat.TeamId}).Decode(&team); err != nil {
+		return err
+	}
+
+	last := "never"
+	if heartbeat.LastPingAt != nil {
+		last = heartbeat.LastPingAt.Format(time.RFC3339)
+	}
+	description := fmt.Sprintf("No ping received within %d minutes (plus %d minutes grace). Last ping: %s", heartbeat.Interval, heartbeat.Grace, last)
+	if heartbeat.Description != "" {
+		description = heartbeat.Description + "\n" + description
+	}
+
+	_, _, err := api.Ingest(team, api.Event{
+		Title:       fmt.Sprintf("Heartbeat %s missed", heartbeat.Name),
+		Description: description,
+		Severity:    heartbeat.Severity,
+		EventType:   api.EventTrigger,
+		DedupKey:    heartbeat.dedupKey(),
+		Source:      "heartbeat",
+		Details:     map[string]interface{}{"heartbeat": heartbeat.Id, "lastPingAt": heartbeat.LastPingAt, "checkedAt": now},
+	})
+	return err
+}
+
+// Ping records a ping and resolves the heartbeat's incident when it was
+// down. It reports whether the heartbeat recovered.
+func Ping(team auth.Team, heartbeat Heartbeat, now time.Time) (bool, error) {
+	filter := bson.M{"id": heartbeat.Id, "teamid": heartbeat.TeamId}
+	update := bson.M{"$set": bson.M{"lastpingat": now, "status": StatusUp, "updatedat": now}}
+
+	if heartbeat.Status != StatusDown {
+		_, err := database.UpdateOne("heartbeats", filter, update)
+		return false, err
+	}
+
+	// only one of concurrent pings resolves
+	filter["status"] = StatusDown
+	result, err := database.UpdateOne("heartbeats", filter, update)
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+
+	_, _, err = api.Ingest(team, api.Event{
+		EventType: api.EventResolve,
+		DedupKey:  heartbeat.dedupKey(),
+	})
+	return true, err
+}
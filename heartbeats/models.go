@@ -0,0 +1,33 @@
+package heartbeats
+
+import (
+	"issue-reporting/incidents"
+	"time"
+)
+
+// Heartbeat is a dead-man's switch. A job pings it at least every Interval
+// minutes and an incident is opened when no ping arrives within Grace
+// minutes after that.
+type Heartbeat struct {
+	Id          string             `json:"id"`
+	TeamId      string             `json:"teamId"`
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Interval    int                `json:"interval"`
+	Grace       int                `json:"grace"`
+	Severity    incidents.Severity `json:"severity"`
+	Paused      bool               `json:"paused"`
+	Status      Status             `json:"status"`
+	LastPingAt  *time.Time         `json:"lastPingAt"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+type Status string
+
+const (
+	// StatusNew heartbeats have not been pinged yet
+	StatusNew  Status = "new"
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+)
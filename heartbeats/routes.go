@@ -0,0 +1,20 @@
+package heartbeats
+
+import (
+	"issue-reporting/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterRoutes(app *fiber.App) {
+	heartbeats := app.Group("/heartbeats").Use(middleware.AuthMiddleware())
+	heartbeats.Post("/", CreateHeartbeat)
+	heartbeats.Get("/", GetHeartbeats)
+	heartbeats.Get("/:id", GetHeartbeat)
+	heartbeats.Put("/:id", UpdateHeartbeat)
+	heartbeats.Delete("/:id", DeleteHeartbeat)
+
+	// pinged by the monitored jobs with the team API key
+	app.Post("/api/v1/heartbeats/:id/ping", middleware.VerifyAPI(), ReceivePing)
+	app.Get("/api/v1/heartbeats/:id/ping", middleware.VerifyAPI(), ReceivePing)
+}
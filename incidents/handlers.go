@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"issue-reporting/auth"
+	"issue-reporting/changes"
 	"issue-reporting/database"
 	"issue-reporting/notification"
 	"issue-reporting/schedules"
@@ -90,8 +91,8 @@ func CreateIncident(c *fiber.Ctx) error {
 		severity = "Unknown"
 	}
 
-	// check who is on-call
-	schedule, err := schedules.Scheduled(time.Now(), user.TeamId)
+	// check who is on-call, falling through to the secondary layer
+	schedule, err := schedules.Responder(time.Now(), user.TeamId)
 	if err != nil {
 		log.Println(err)
 		return c.Status(fiber.StatusExpectationFailed).JSON(fiber.Map{
@@ -173,7 +174,7 @@ func CreateIncident(c *fiber.Ctx) error {
 	if len(incident.AssignedTo) > 0 {
 		for _, user := range incident.AssignedTo {
 			// assignedToNames[i] = fmt.Sprintf("%s <%s>", user.Name, user.GithubHandle)
-			notification.SendNotification(fmt.Sprintf("You have been assigned to: \nIncident #%s\nTitle: %s\nDescription: %s\nSeverity: %s", incident.Id, incident.Title, incident.Description, incident.Severity), user)
+			notification.SendPage(fmt.Sprintf("You have been assigned to: \nIncident #%s\nTitle: %s\nDescription: %s\nSeverity: %s", incident.Id, incident.Title, incident.Description, incident.Severity), user, incident.Id)
 		}
 	}
 
@@ -203,9 +204,27 @@ func GetIncident(c *fiber.Ctx) error {
 		})
 	}
 
+	// what changed on the service shortly before it broke
+	lookback, err := changes.Lookback(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+	// without a service there is nothing to tie changes to the incident
+	recent := []changes.Change{}
+	if incident.Source != "" {
+		recent, err = changes.Before(incident.TeamId, incident.Source, incident.CreatedAt, lookback)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
 	return c.Status(200).JSON(fiber.Map{
 		"message":  "incident data",
 		"incident": &incident,
+		"changes":  recent,
 	})
 }
 
@@ -298,6 +317,21 @@ func GetIncidents(c *fiber.Ctx) error {
 	})
 }
 
+// editableFields maps the incident fields clients may change to their
+// stored names. Everything else is kept up to date by the server.
+var editableFields = map[string]string{
+	"title":             "title",
+	"description":       "description",
+	"severity":          "severity",
+	"status":            "status",
+	"actions":           "actions",
+	"followUps":         "followups",
+	"metadata":          "metadata",
+	"reportCreated":     "reportcreated",
+	"escalation_policy": "escalationpolicy",
+	"tags":              "tags",
+}
+
 func UpdateIncident(c *fiber.Ctx) error {
 	// Parse the incoming request body to extract the fields to update
 	var incidentUpdate map[string]interface{}
@@ -312,19 +346,19 @@ func UpdateIncident(c *fiber.Ctx) error {
 	// Build the filter to find the incident by their code
 	filter := bson.M{"id": incidentCode}
 
-	// Initialize the update operation
-	var update bson.M
-	if len(incidentUpdate) > 0 {
-		delete(incidentUpdate, "id")
-		delete(incidentUpdate, "created_at")
-		delete(incidentUpdate, "teamId")
-		delete(incidentUpdate, "acknowledged_at")
-		delete(incidentUpdate, "assigned_to")
-		update = bson.M{"$set": incidentUpdate}
-	} else {
+	// Only fields clients may edit are set, the rest are ignored
+	fields := bson.M{}
+	for key, value := range incidentUpdate {
+		if name, ok := editableFields[key]; ok {
+			fields[name] = value
+		}
+	}
+	if len(fields) == 0 {
 		// If no fields provided, return an error or handle it as needed
 		return fiber.NewError(fiber.StatusBadRequest, "No fields provided for update")
 	}
+	fields["updatedat"] = time.Now()
+	update := bson.M{"$set": fields}
 
 	// Perform the update operation
 	var incident Incident
@@ -617,7 +651,7 @@ func Assign(incidentId string, params *AssignParams) (*Incident, error) {
 	if len(incident.AssignedTo) > 0 {
 		for _, user := range incident.AssignedTo {
 			// assignedToNames[i] = fmt.Sprintf("%s <%s>", user.Name, user.GithubHandle)
-			notification.SendNotification(fmt.Sprintf("You have been assigned to: \nIncident #%s\nTitle: %s\nDescription: %s\nSeverity: %s", incident.Id, incident.Title, incident.Description, incident.Severity), user)
+			notification.SendPage(fmt.Sprintf("You have been assigned to: \nIncident #%s\nTitle: %s\nDescription: %s\nSeverity: %s", incident.Id, incident.Title, incident.Description, incident.Severity), user, incident.Id)
 		}
 	}
 
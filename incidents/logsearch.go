@@ -0,0 +1,170 @@
+package incidents
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+// logCursor points just past the last log of a search page. Logs are
+// ordered newest first with the id breaking ties.
+type logCursor struct {
+	CreatedAt time.Time
+	Id        string
+}
+
+func (c logCursor) encode() string {
+	raw := fmt.Sprintf("%d:%s", c.CreatedAt.UnixMilli(), c.Id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeLogCursor(value string) (*logCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("cursor is not valid")
+	}
+	millis, id, found := strings.Cut(string(raw), ":")
+	if !found {
+		return nil, errors.New("cursor is not valid")
+	}
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return nil, errors.New("cursor is not valid")
+	}
+	return &logCursor{CreatedAt: time.UnixMilli(ms), Id: id}, nil
+}
+
+// SearchLogs finds the team's logs by time range (from and to, RFC3339),
+// app, slug and free text in the title or description (q). Pages are
+// limit long and the next one is fetched by passing back next_cursor.
+func SearchLogs(c *fiber.Ctx) error {
+	ctx := context.Background()
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter, err := logSearchFilter(user.TeamId, c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	limit := defaultSearchLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Bad Request",
+				"message": "limit must be a positive number",
+			})
+		}
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+	}
+
+	// one extra log tells whether there is another page
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdat", Value: -1}, {Key: "id", Value: -1}}).
+		SetLimit(int64(limit + 1))
+
+	cursor, err := database.GetDatabase().Database("IssueReporting").Collection("logs").Find(ctx, filter, opts)
+	if err != nil {
+		return fmt.Errorf("error finding logs: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	logs := []Log{}
+	if err := cursor.All(ctx, &logs); err != nil {
+		return fmt.Errorf("error decoding logs: %v", err)
+	}
+
+	next := ""
+	if len(logs) > limit {
+		logs = logs[:limit]
+		last := logs[len(logs)-1]
+		next = logCursor{CreatedAt: last.CreatedAt, Id: last.Id}.encode()
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":     "logs data",
+		"logs":        logs,
+		"next_cursor": next,
+	})
+}
+
+func logSearchFilter(teamId string, c *fiber.Ctx) (bson.M, error) {
+	filter := bson.M{"teamid": teamId}
+	var conditions []bson.M
+
+	createdAt := bson.M{}
+	if from := c.Query("from"); from != "" {
+		start, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, errors.New("from timestamp is not in a valid format")
+		}
+		createdAt["$gte"] = start
+	}
+	if to := c.Query("to"); to != "" {
+		end, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, errors.New("to timestamp is not in a valid format")
+		}
+		createdAt["$lt"] = end
+	}
+	if len(createdAt) > 0 {
+		filter["createdat"] = createdAt
+	}
+
+	if app := c.Query("app"); app != "" {
+		filter["app"] = app
+	}
+	if slug := c.Query("slug"); slug != "" {
+		filter["slug"] = slug
+	}
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := bson.M{"$regex": regexp.QuoteMeta(q), "$options": "i"}
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"title": pattern},
+			{"description": pattern},
+		}})
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		after, err := decodeLogCursor(value)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, bson.M{"$or": []bson.M{
+			{"createdat": bson.M{"$lt": after.CreatedAt}},
+			{"createdat": after.CreatedAt, "id": bson.M{"$lt": after.Id}},
+		}})
+	}
+
+	if len(conditions) > 0 {
+		filter["$and"] = conditions
+	}
+	return filter, nil
+}
@@ -24,6 +24,46 @@ type Incident struct {
 	Timeline       []Timepoint `json:"timeline"`
 	Metadata       string      `json:"metadata"`
 	ReportCreated  bool        `json:"reportCreated"`
+
+	EscalationPolicy  string    `json:"escalation_policy"`
+	EscalationLevel   int       `json:"escalation_level"`
+	EscalationRepeats int       `json:"escalation_repeats"`
+	EscalatedAt       time.Time `json:"escalated_at"`
+
+	DedupKey   string                 `json:"dedup_key"`
+	AlertCount int                    `json:"alert_count"`
+	Source     string                 `json:"source"`
+	Details    map[string]interface{} `json:"details"`
+	Tags       []string               `json:"tags"`
+
+	// Integration is the id of the integration that raised the incident
+	Integration string `json:"integration"`
+
+	// Suppressed incidents came in during the maintenance window Maintenance
+	// and are neither assigned nor paged
+	Suppressed  bool   `json:"suppressed"`
+	Maintenance string `json:"maintenance"`
+
+	// Flapping incidents keep changing state and are held open until
+	// their alert settles
+	Flapping bool `json:"flapping"`
+
+	// Alerts grouped into the incident, the alert that opened it first.
+	// Only set for teams that group alerts.
+	GroupKey string  `json:"group_key"`
+	Alerts   []Alert `json:"alerts"`
+}
+
+// Alert is one alert grouped into an incident
+type Alert struct {
+	Title       string                 `json:"title"`
+	Description string                 `json:"description"`
+	Severity    Severity               `json:"severity"`
+	Source      string                 `json:"source"`
+	DedupKey    string                 `json:"dedup_key"`
+	Details     map[string]interface{} `json:"details"`
+	Resolved    bool                   `json:"resolved"`
+	ReceivedAt  time.Time              `json:"received_at"`
 }
 
 type Incidents struct {
@@ -20,4 +20,5 @@ func RegisterRoutes(app *fiber.App) {
 
 	logRoutes := app.Group("/log").Use(middleware.AuthMiddleware())
 	logRoutes.Get("/", GetLogs)
+	logRoutes.Get("/search", SearchLogs)
 }
@@ -0,0 +1,375 @@
+package integrations
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"issue-reporting/api"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/utils"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxDescription caps how much of an email body ends up on the incident
+const maxDescription = 10000
+
+// Email is the part of an RFC 5322 message the gateway uses
+type Email struct {
+	MessageId  string   `json:"messageId"`
+	From       string   `json:"from"`
+	Recipients []string `json:"recipients"`
+	Subject    string   `json:"subject"`
+	Date       string   `json:"date"`
+	Text       string   `json:"text"`
+}
+
+var (
+	htmlTags   = regexp.MustCompile(`(?s)<(script|style)[^>]*>.*?</(script|style)>|<[^>]+>`)
+	blankLines = regexp.MustCompile(`\n\s*\n\s*\n+`)
+)
+
+// ParseEmail reads a raw message, preferring its text/plain part and
+// falling back to the text of an HTML part
+func ParseEmail(r io.Reader) (*Email, error) {
+	message, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(message.Header.Get("Subject"))
+	if err != nil {
+		subject = message.Header.Get("Subject")
+	}
+
+	email := &Email{
+		MessageId: message.Header.Get("Message-Id"),
+		Subject:   strings.TrimSpace(subject),
+		Date:      message.Header.Get("Date"),
+	}
+	if from, err := mail.ParseAddress(message.Header.Get("From")); err == nil {
+		email.From = from.Address
+	} else {
+		email.From = message.Header.Get("From")
+	}
+
+	// relays put the envelope recipient in these when To is a list
+	for _, header := range []string{"Delivered-To", "X-Original-To", "To", "Cc"} {
+		addresses, err := mail.ParseAddressList(message.Header.Get(header))
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			email.Recipients = append(email.Recipients, address.Address)
+		}
+	}
+
+	plain, htmlText, err := readPart(message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body)
+	if err != nil {
+		return nil, err
+	}
+	text := plain
+	if strings.TrimSpace(text) == "" {
+		text = blankLines.ReplaceAllString(html.UnescapeString(htmlTags.ReplaceAllString(htmlText, "")), "\n\n")
+	}
+	email.Text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+
+	return email, nil
+}
+
+// readPart returns the first text/plain and text/html content of a part,
+// walking into multipart bodies
+func readPart(contentType, encoding string, body io.Reader) (string, string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var plain, htmlText string
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", "", err
+			}
+			if strings.HasPrefix(part.Header.Get("Content-Disposition"), "attachment") {
+				continue
+			}
+			p, h, err := readPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return "", "", err
+			}
+			if plain == "" {
+				plain = p
+			}
+			if htmlText == "" {
+				htmlText = h
+			}
+		}
+		return plain, htmlText, nil
+	}
+
+	var decoded io.Reader = body
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		decoded = quotedprintable.NewReader(body)
+	case "base64":
+		decoded = base64.NewDecoder(base64.StdEncoding, newlineStripper{body})
+	}
+	data, err := io.ReadAll(decoded)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch mediaType {
+	case "text/plain":
+		return string(data), "", nil
+	case "text/html":
+		return "", string(data), nil
+	}
+	return "", "", nil
+}
+
+// newlineStripper drops line breaks so base64 bodies decode
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (s newlineStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	out := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[out] = b
+			out++
+		}
+	}
+	return out, err
+}
+
+// ToEvent turns an email into an event using the rules
+func (rules EmailRules) ToEvent(email *Email) (api.Event, error) {
+	event := api.Event{
+		Title:       email.Subject,
+		Description: email.Text,
+		Severity:    incidents.SeverityLow,
+		EventType:   api.EventTrigger,
+		Source:      email.From,
+		Details: map[string]interface{}{
+			"from":      email.From,
+			"messageId": email.MessageId,
+			"date":      email.Date,
+		},
+	}
+	if event.Title == "" {
+		event.Title = "Email alert from " + email.From
+	}
+	event.Description = utils.Truncate(event.Description, maxDescription)
+
+	for _, rule := range rules.Severities {
+		matched, err := matchEmail(rule.Pattern, email)
+		if err != nil {
+			return event, err
+		}
+		if matched != nil {
+			event.Severity = rule.Severity
+			break
+		}
+	}
+
+	if rules.DedupKey != "" {
+		matched, err := matchEmail(rules.DedupKey, email)
+		if err != nil {
+			return event, err
+		}
+		if len(matched) > 1 {
+			event.DedupKey = matched[1]
+		} else if len(matched) == 1 {
+			event.DedupKey = matched[0]
+		}
+	}
+
+	for _, action := range []struct {
+		pattern   string
+		eventType api.EventType
+	}{
+		{rules.Resolve, api.EventResolve},
+		{rules.Acknowledge, api.EventAcknowledge},
+	} {
+		if action.pattern == "" {
+			continue
+		}
+		matched, err := matchEmail(action.pattern, email)
+		if err != nil {
+			return event, err
+		}
+		if matched != nil {
+			event.EventType = action.eventType
+			break
+		}
+	}
+
+	return event, nil
+}
+
+// matchEmail matches pattern against the subject, then the body
+func matchEmail(pattern string, email *Email) ([]string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if matched := re.FindStringSubmatch(email.Subject); matched != nil {
+		return matched, nil
+	}
+	return re.FindStringSubmatch(email.Text), nil
+}
+
+func VerifyEmailRules(rules *EmailRules) error {
+	if rules == nil {
+		return nil
+	}
+	for i, rule := range rules.Severities {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("severity rule %d: %v", i+1, err)
+		}
+		if rule.Severity != incidents.SeverityLow && rule.Severity != incidents.SeverityMedium && rule.Severity != incidents.SeverityHigh {
+			return fmt.Errorf("severity rule %d: severity must be Low, Medium or High", i+1)
+		}
+	}
+	for name, pattern := range map[string]string{"dedupKey": rules.DedupKey, "resolve": rules.Resolve, "acknowledge": rules.Acknowledge} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// EmailAddress is where an email integration receives alerts. The local
+// part is the integration key.
+func EmailAddress(integration Integration) string {
+	domain := os.Getenv("INBOUND_EMAIL_DOMAIN")
+	if domain == "" {
+		return ""
+	}
+	return integration.Key + "@" + domain
+}
+
+// ReceiveEmail takes a raw RFC 5322 message, as forwarded by an inbound mail
+// relay or piped from an .eml file, and raises an incident for the email
+// integration it was addressed to. The recipient can also be given in the
+// to query when the relay passes the envelope recipient separately, and
+// dryRun=true only returns the parsed email and event.
+func ReceiveEmail(c *fiber.Ctx) error {
+	email, err := ParseEmail(bytes.NewReader(c.Body()))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "invalid email: " + err.Error(),
+		})
+	}
+
+	recipients := email.Recipients
+	if to := c.Query("to"); to != "" {
+		recipients = []string{to}
+	}
+	integration, err := emailIntegration(recipients)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "Not Found",
+			"message": err.Error(),
+		})
+	}
+
+	var rules EmailRules
+	if integration.Email != nil {
+		rules = *integration.Email
+	}
+	event, err := rules.ToEvent(email)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong applying email rules")
+	}
+	event.Integration = integration.Id
+
+	if c.QueryBool("dryRun") {
+		return c.Status(200).JSON(fiber.Map{
+			"message": "email parsed",
+			"email":   email,
+			"event":   event,
+		})
+	}
+
+	if err := api.VerifyEvent(event); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	var team auth.Team
+	err = database.FindOne("teams", bson.M{"teamId": integration.TeamId}).Decode(&team)
+	if err != nil {
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	incident, outcome, err := api.Ingest(team, event)
+	if err != nil {
+		log.Println(err)
+		return c.Status(fiber.StatusExpectationFailed).JSON(fiber.Map{
+			"message": err.Error(),
+			"status":  false,
+		})
+	}
+
+	response := fiber.Map{
+		"message": "incident " + string(outcome),
+		"outcome": outcome,
+	}
+	if incident != nil {
+		response["incident"] = incident.Id
+	}
+	return c.Status(200).JSON(response)
+}
+
+// emailIntegration finds the email integration whose key is the local part
+// of one of the recipients. Plus addressing is ignored.
+func emailIntegration(recipients []string) (*Integration, error) {
+	for _, recipient := range recipients {
+		local := strings.ToLower(recipient)
+		if at := strings.LastIndex(local, "@"); at != -1 {
+			local = local[:at]
+		}
+		if plus := strings.Index(local, "+"); plus != -1 {
+			local = local[:plus]
+		}
+		if local == "" {
+			continue
+		}
+
+		var integration Integration
+		err := database.FindOne("integrations", bson.M{"key": local, "type": TypeEmail, "disabled": false}).Decode(&integration)
+		if err == nil {
+			return &integration, nil
+		}
+	}
+	return nil, errors.New("no email integration for these recipients")
+}
@@ -0,0 +1,192 @@
+package integrations
+
+import (
+	"bytes"
+	"issue-reporting/api"
+	"issue-reporting/incidents"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func parseFixture(t *testing.T, name string) *Email {
+	t.Helper()
+	email, err := ParseEmail(bytes.NewReader(readFixture(t, name)))
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return email
+}
+
+func TestParseEmail(t *testing.T) {
+	tests := []struct {
+		fixture    string
+		from       string
+		subject    string
+		recipients []string
+		text       string
+		contains   string
+		notText    string
+	}{
+		{
+			fixture:    "plain.eml",
+			from:       "alerts@monitor.example.com",
+			subject:    "[CRITICAL] Check #4821 api.example.com is DOWN",
+			recipients: []string{"3f9a1c@alerts.example.org", "oncall@example.org"},
+			text:       "Check #4821 (api.example.com) failed from 3 locations.\n\nReason: connection timed out after 30s\nSince: 2024-04-15 13:19:41 UTC",
+		},
+		{
+			fixture:    "multipart.eml",
+			from:       "noreply@backup.example.net",
+			subject:    "Nightly backup job-77 RESOLVED",
+			recipients: []string{"ops@example.org", "3f9a1c@alerts.example.org"},
+			text:       "Backup job-77 completed successfully after 3 retries.\nTotal size: 12.4 GB",
+			notText:    "retry 1 failed",
+		},
+		{
+			fixture:    "quoted_printable.eml",
+			from:       "monitor@example.ch",
+			subject:    "Warnung: Speicher fast voll auf db-2 – 92%",
+			recipients: []string{"3f9a1c@alerts.example.org"},
+			text:       "Der Speicher auf db-2 ist zu 92 % belegt. Bitte prüfen Sie die Protokolldateien unter /var/log/postgresql, die seit gestern stark gewachsen sind.\n\nSchwellenwert: 90 %\nHost: db-2 – München",
+		},
+		{
+			fixture:    "html_only.eml",
+			from:       "status@example.com",
+			subject:    "Payment provider degraded",
+			recipients: []string{"3f9a1c@alerts.example.org"},
+			contains:   "Card payments are slow & some fail.",
+			notText:    "color: red",
+		},
+	}
+
+	for _, tt := range tests {
+		email := parseFixture(t, tt.fixture)
+		if email.From != tt.from {
+			t.Errorf("%s: from %q, want %q", tt.fixture, email.From, tt.from)
+		}
+		if email.Subject != tt.subject {
+			t.Errorf("%s: subject %q, want %q", tt.fixture, email.Subject, tt.subject)
+		}
+		if !reflect.DeepEqual(email.Recipients, tt.recipients) {
+			t.Errorf("%s: recipients %q, want %q", tt.fixture, email.Recipients, tt.recipients)
+		}
+		if tt.text != "" && email.Text != tt.text {
+			t.Errorf("%s: text %q, want %q", tt.fixture, email.Text, tt.text)
+		}
+		if !strings.Contains(email.Text, tt.contains) {
+			t.Errorf("%s: text %q is missing %q", tt.fixture, email.Text, tt.contains)
+		}
+		if tt.notText != "" && strings.Contains(email.Text, tt.notText) {
+			t.Errorf("%s: text contains %q", tt.fixture, tt.notText)
+		}
+		if email.MessageId == "" || email.Date == "" {
+			t.Errorf("%s: message id %q, date %q", tt.fixture, email.MessageId, email.Date)
+		}
+	}
+
+	if _, err := ParseEmail(strings.NewReader("not an email")); err == nil {
+		t.Error("ParseEmail accepted a body without headers")
+	}
+}
+
+var testEmailRules = EmailRules{
+	Severities: []SeverityRule{
+		{Pattern: `(?i)\[critical\]|DOWN`, Severity: incidents.SeverityHigh},
+		{Pattern: `(?i)warn`, Severity: incidents.SeverityMedium},
+	},
+	DedupKey:    `(?:Check #|job-|auf )([\w-]+)`,
+	Resolve:     `(?i)resolved|completed successfully`,
+	Acknowledge: `(?i)acknowledged`,
+}
+
+func TestEmailRulesToEvent(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		severity  incidents.Severity
+		dedupKey  string
+		eventType api.EventType
+	}{
+		{"plain.eml", incidents.SeverityHigh, "4821", api.EventTrigger},
+		{"multipart.eml", incidents.SeverityLow, "77", api.EventResolve},
+		{"quoted_printable.eml", incidents.SeverityMedium, "db-2", api.EventTrigger},
+		{"html_only.eml", incidents.SeverityLow, "", api.EventTrigger},
+	}
+
+	for _, tt := range tests {
+		email := parseFixture(t, tt.fixture)
+		event, err := testEmailRules.ToEvent(email)
+		if err != nil {
+			t.Errorf("%s: %v", tt.fixture, err)
+			continue
+		}
+		if event.Severity != tt.severity || event.DedupKey != tt.dedupKey || event.EventType != tt.eventType {
+			t.Errorf("%s: severity %q, dedup key %q, type %q; want %q, %q, %q", tt.fixture, event.Severity, event.DedupKey, event.EventType, tt.severity, tt.dedupKey, tt.eventType)
+		}
+		if event.Title != email.Subject || event.Description != email.Text || event.Source != email.From {
+			t.Errorf("%s: title %q, source %q", tt.fixture, event.Title, event.Source)
+		}
+	}
+}
+
+func TestEmailRulesDefaults(t *testing.T) {
+	event, err := EmailRules{}.ToEvent(&Email{From: "alerts@example.com", Text: "something broke"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Title != "Email alert from alerts@example.com" {
+		t.Errorf("title %q", event.Title)
+	}
+	if event.Severity != incidents.SeverityLow || event.EventType != api.EventTrigger || event.DedupKey != "" {
+		t.Errorf("severity %q, type %q, dedup key %q", event.Severity, event.EventType, event.DedupKey)
+	}
+
+	// without a capture group the whole match is the key
+	event, err = EmailRules{DedupKey: `host-\d+`}.ToEvent(&Email{Subject: "disk full on host-12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.DedupKey != "host-12" {
+		t.Errorf("dedup key %q, want the whole match", event.DedupKey)
+	}
+
+	if _, err := (EmailRules{Resolve: `(`}).ToEvent(&Email{Subject: "x"}); err == nil {
+		t.Error("invalid pattern was accepted")
+	}
+}
+
+func TestEmailDescriptionTruncation(t *testing.T) {
+	// a multi-byte character straddles the limit
+	text := strings.Repeat("a", maxDescription-1) + "ü" + strings.Repeat("b", 10)
+	event, err := EmailRules{}.ToEvent(&Email{Subject: "long", Text: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utf8.ValidString(event.Description) {
+		t.Error("truncated description is not valid UTF-8")
+	}
+	if event.Description != strings.Repeat("a", maxDescription-1) {
+		t.Errorf("description is %d bytes, want %d", len(event.Description), maxDescription-1)
+	}
+}
+
+func TestVerifyEmailRules(t *testing.T) {
+	if err := VerifyEmailRules(nil); err != nil {
+		t.Errorf("nil rules: %v", err)
+	}
+	if err := VerifyEmailRules(&testEmailRules); err != nil {
+		t.Errorf("valid rules: %v", err)
+	}
+	invalid := []EmailRules{
+		{Severities: []SeverityRule{{Pattern: "(", Severity: incidents.SeverityHigh}}},
+		{Severities: []SeverityRule{{Pattern: "down", Severity: "Critical"}}},
+		{DedupKey: "["},
+		{Acknowledge: "*"},
+	}
+	for _, rules := range invalid {
+		if err := VerifyEmailRules(&rules); err == nil {
+			t.Errorf("VerifyEmailRules(%+v) succeeded", rules)
+		}
+	}
+}
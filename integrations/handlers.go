@@ -0,0 +1,323 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"issue-reporting/api"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/utils"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func CreateIntegration(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var integration Integration
+	if err := c.BodyParser(&integration); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if err := VerifyIntegration(integration); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	key, err := generateKey()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	integration.Id = code
+	integration.Key = key
+	integration.TeamId = user.TeamId
+	integration.Disabled = false
+	integration.CreatedAt = time.Now()
+	integration.UpdatedAt = time.Now()
+
+	_, err = database.InsertOne("integrations", integration)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "integration not created")
+	}
+
+	response := fiber.Map{
+		"message":     "integration created",
+		"integration": integration,
+		"url":         "/webhooks/" + integration.Key,
+	}
+	if integration.Type == TypeEmail {
+		response["address"] = EmailAddress(integration)
+	}
+	return c.Status(200).JSON(response)
+}
+
+func GetIntegrations(c *fiber.Ctx) error {
+	ctx := context.Background()
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	cursor, err := database.Find("integrations", bson.M{"teamid": user.TeamId})
+	if err != nil {
+		return fmt.Errorf("error finding integrations: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var integrations []Integration
+	if err := cursor.All(ctx, &integrations); err != nil {
+		return fmt.Errorf("error decoding integrations: %v", err)
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":      "integrations data",
+		"integrations": integrations,
+	})
+}
+
+func GetIntegration(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var integration Integration
+	err = database.FindOne("integrations", bson.M{"id": c.Params("id"), "teamid": user.TeamId}).Decode(&integration)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusNotFound, "No integration found")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":     "integration data",
+		"integration": &integration,
+	})
+}
+
+func UpdateIntegration(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var body Integration
+	if err := c.BodyParser(&body); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if err := VerifyIntegration(body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+	update := bson.M{"$set": bson.M{
+		"name":      body.Name,
+		"type":      body.Type,
+		"mapping":   body.Mapping,
+		"email":     body.Email,
+		"disabled":  body.Disabled,
+		"updatedat": time.Now(),
+	}}
+
+	var integration Integration
+	err = database.FindOneAndUpdate("integrations", filter, update).Decode(&integration)
+	if err != nil {
+		return fiber.NewError(fiber.StatusNoContent, err.Error())
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "integration updated",
+		"integration": &integration,
+	})
+}
+
+// RotateKey replaces the key of an integration, the old key stops working
+// straight away
+func RotateKey(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	key, err := generateKey()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+	update := bson.M{"$set": bson.M{"key": key, "updatedat": time.Now()}}
+
+	var integration Integration
+	err = database.FindOneAndUpdate("integrations", filter, update).Decode(&integration)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusNotFound, "No integration found")
+	}
+
+	response := fiber.Map{
+		"message":     "integration key rotated",
+		"integration": &integration,
+		"url":         "/webhooks/" + integration.Key,
+	}
+	if integration.Type == TypeEmail {
+		response["address"] = EmailAddress(integration)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func DeleteIntegration(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+
+	var integration Integration
+	err = database.FindOne("integrations", filter).Decode(&integration)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusExpectationFailed, "No integration found")
+	}
+
+	_, err = database.InsertOne("deletedintegrations", integration)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	_, err = database.DeleteOne("integrations", filter)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":     "integration deleted",
+		"integration": &integration,
+	})
+}
+
+// Receive turns a webhook body into incidents according to the type of the
+// integration whose key it was sent with
+func Receive(c *fiber.Ctx) error {
+	teamId := c.Locals("teamId").(string)
+	integrationId := c.Locals("integrationId").(string)
+
+	var team auth.Team
+	err := database.FindOne("teams", bson.M{"teamId": teamId}).Decode(&team)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Invalid integration key"})
+	}
+
+	var integration Integration
+	err = database.FindOne("integrations", bson.M{"id": integrationId, "teamid": teamId}).Decode(&integration)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Invalid integration key"})
+	}
+
+	events, err := ToEvents(integration, c.Body())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": fmt.Sprintf("invalid %s payload: %v", integration.Type, err),
+		})
+	}
+
+	var results []fiber.Map
+	failed := 0
+	for _, event := range events {
+		incident, outcome, err := api.Ingest(team, event)
+		result := fiber.Map{"dedup_key": event.DedupKey, "outcome": outcome}
+		if err != nil {
+			log.Println(err)
+			failed++
+			result["error"] = err.Error()
+		}
+		if incident != nil {
+			result["incident"] = incident.Id
+		}
+		results = append(results, result)
+	}
+
+	status := 200
+	if failed > 0 {
+		status = fiber.StatusInternalServerError
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message": fmt.Sprintf("%d events received, %d failed", len(events), failed),
+		"results": results,
+	})
+}
+
+func VerifyIntegration(integration Integration) error {
+	if integration.Name == "" {
+		return errors.New("integration name is required")
+	}
+
+	known := false
+	for _, t := range Types {
+		if integration.Type == t {
+			known = true
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown integration type %q", integration.Type)
+	}
+
+	switch integration.Type {
+	case TypeGeneric:
+		return VerifyMapping(integration.Mapping)
+	case TypeEmail:
+		return VerifyEmailRules(integration.Email)
+	}
+	return nil
+}
+
+func generateKey() (string, error) {
+	return utils.GenerateRandomCode(32)
+}
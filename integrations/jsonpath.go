@@ -0,0 +1,149 @@
+package integrations
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// step is one part of a parsed path, either a field name or an array index
+type step struct {
+	field string
+	index int
+	isIdx bool
+}
+
+// parsePath parses the JSONPath subset used by mappings: $ followed by
+// .field, ['field'] or [index] steps.
+func parsePath(path string) ([]step, error) {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path %q must start with $", path)
+	}
+
+	var steps []step
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("path %q has an empty field name", path)
+			}
+			steps = append(steps, step{field: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("path %q has an unclosed [", path)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				steps = append(steps, step{field: inner[1 : len(inner)-1]})
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("path %q has an invalid index %q", path, inner)
+			}
+			steps = append(steps, step{index: index, isIdx: true})
+		default:
+			return nil, fmt.Errorf("path %q is invalid near %q", path, rest)
+		}
+	}
+	return steps, nil
+}
+
+// lookup returns the value at path in a decoded JSON document, or nil when
+// the document has nothing there
+func lookup(doc interface{}, path string) (interface{}, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	value := doc
+	for _, s := range steps {
+		switch current := value.(type) {
+		case map[string]interface{}:
+			if s.isIdx {
+				return nil, nil
+			}
+			value = current[s.field]
+		case []interface{}:
+			if !s.isIdx {
+				return nil, nil
+			}
+			if s.index < 0 {
+				s.index += len(current)
+			}
+			if s.index < 0 || s.index >= len(current) {
+				return nil, nil
+			}
+			value = current[s.index]
+		default:
+			return nil, nil
+		}
+	}
+	return value, nil
+}
+
+// render fills a mapping template from doc
+func render(doc interface{}, template string) (string, error) {
+	trimmed := strings.TrimSpace(template)
+	if strings.HasPrefix(trimmed, "$") && !strings.Contains(trimmed, "{{") {
+		value, err := lookup(doc, trimmed)
+		if err != nil {
+			return "", err
+		}
+		return stringify(value), nil
+	}
+
+	var b strings.Builder
+	rest := template
+	for {
+		start := strings.Index(rest, "{{")
+		if start == -1 {
+			b.WriteString(rest)
+			break
+		}
+		end := strings.Index(rest[start:], "}}")
+		if end == -1 {
+			return "", fmt.Errorf("template %q has an unclosed {{", template)
+		}
+		b.WriteString(rest[:start])
+
+		value, err := lookup(doc, rest[start+2:start+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(stringify(value))
+		rest = rest[start+end+2:]
+	}
+	return b.String(), nil
+}
+
+func stringify(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
@@ -0,0 +1,140 @@
+package integrations
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []step
+		wantErr bool
+	}{
+		{path: "$", want: nil},
+		{path: "$.alert.title", want: []step{{field: "alert"}, {field: "title"}}},
+		{path: " $.title ", want: []step{{field: "title"}}},
+		{path: "$['alert']['rule name']", want: []step{{field: "alert"}, {field: "rule name"}}},
+		{path: `$["alert"].labels`, want: []step{{field: "alert"}, {field: "labels"}}},
+		{path: "$.alerts[0].labels", want: []step{{field: "alerts"}, {index: 0, isIdx: true}, {field: "labels"}}},
+		{path: "$.alerts[-1]", want: []step{{field: "alerts"}, {index: -1, isIdx: true}}},
+		{path: "$[ 2 ]", want: []step{{index: 2, isIdx: true}}},
+		{path: "alert.title", wantErr: true},
+		{path: "$..title", wantErr: true},
+		{path: "$.alerts[0", wantErr: true},
+		{path: "$.alerts[first]", wantErr: true},
+		{path: "$title", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePath(tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePath(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+const lookupDoc = `{
+	"title": "Disk full",
+	"count": 3,
+	"ratio": 0.25,
+	"firing": true,
+	"missing": null,
+	"labels": {"host": "db-1", "rule name": "disk"},
+	"alerts": [
+		{"id": "a"},
+		{"id": "b"},
+		{"id": "c", "tags": ["x", "y"]}
+	]
+}`
+
+func decode(t *testing.T, body string) interface{} {
+	t.Helper()
+	var doc interface{}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestLookup(t *testing.T) {
+	doc := decode(t, lookupDoc)
+	tests := []struct {
+		path string
+		want interface{}
+	}{
+		{"$.title", "Disk full"},
+		{"$.count", float64(3)},
+		{"$.labels.host", "db-1"},
+		{"$.labels['rule name']", "disk"},
+		{"$.alerts[0].id", "a"},
+		{"$.alerts[-1].id", "c"},
+		{"$.alerts[-3].id", "a"},
+		{"$.alerts[2].tags[-2]", "x"},
+
+		// anything that is not there reads as nil
+		{"$.nothing", nil},
+		{"$.nothing.deeper", nil},
+		{"$.missing", nil},
+		{"$.alerts[3]", nil},
+		{"$.alerts[-4]", nil},
+		{"$.alerts.id", nil},
+		{"$.labels[0]", nil},
+		{"$.title.length", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := lookup(doc, tt.path)
+		if err != nil {
+			t.Errorf("lookup(%q) error = %v", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lookup(%q) = %#v, want %#v", tt.path, got, tt.want)
+		}
+	}
+
+	if _, err := lookup(doc, "title"); err == nil {
+		t.Error("lookup of a path without $ succeeded")
+	}
+}
+
+func TestRender(t *testing.T) {
+	doc := decode(t, lookupDoc)
+	tests := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{template: "$.title", want: "Disk full"},
+		{template: "$.count", want: "3"},
+		{template: "$.ratio", want: "0.25"},
+		{template: "$.firing", want: "true"},
+		{template: "$.labels", want: `{"host":"db-1","rule name":"disk"}`},
+		{template: "$.alerts[2].tags", want: `["x","y"]`},
+		{template: "$.nothing", want: ""},
+		{template: "{{ $.title }} on {{$.labels.host}}", want: "Disk full on db-1"},
+		{template: "seen {{ $.count }} times, last {{ $.alerts[-1].id }}", want: "seen 3 times, last c"},
+		{template: "host {{ $.labels.unknown }}!", want: "host !"},
+		{template: "plain text", want: "plain text"},
+		{template: "", want: ""},
+		{template: "{{ $.title", wantErr: true},
+		{template: "{{ title }}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := render(doc, tt.template)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("render(%q) error = %v, wantErr %v", tt.template, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("render(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package integrations
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"issue-reporting/api"
+	"issue-reporting/incidents"
+	"strings"
+)
+
+// ToEvents reads the events in a body sent to an integration
+func ToEvents(integration Integration, body []byte) ([]api.Event, error) {
+	var events []api.Event
+
+	switch integration.Type {
+	case TypeAPI:
+		var event api.Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	case TypeGeneric:
+		if integration.Mapping == nil {
+			return nil, errors.New("integration has no mapping")
+		}
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return nil, err
+		}
+		event, err := integration.Mapping.Apply(doc)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	case TypeAlertmanager:
+		var webhook api.AlertmanagerWebhook
+		if err := json.Unmarshal(body, &webhook); err != nil {
+			return nil, err
+		}
+		for _, alert := range webhook.Alerts {
+			events = append(events, alert.ToEvent(webhook))
+		}
+	case TypePagerDuty:
+		var pagerDutyEvent api.PagerDutyEvent
+		if err := json.Unmarshal(body, &pagerDutyEvent); err != nil {
+			return nil, err
+		}
+		event, problems := pagerDutyEvent.ToEvent()
+		if len(problems) > 0 {
+			return nil, errors.New(strings.Join(problems, ", "))
+		}
+		events = append(events, event)
+	case TypeGrafana:
+		grafanaEvents, err := grafanaToEvents(body)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, grafanaEvents...)
+	case TypeSentry:
+		sentryEvents, err := sentryToEvents(body)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, sentryEvents...)
+	case TypeEmail:
+		email, err := ParseEmail(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		var rules EmailRules
+		if integration.Email != nil {
+			rules = *integration.Email
+		}
+		event, err := rules.ToEvent(email)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	default:
+		return nil, fmt.Errorf("unknown integration type %q", integration.Type)
+	}
+
+	for i := range events {
+		events[i].Integration = integration.Id
+		if err := api.VerifyEvent(events[i]); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// Apply renders an event out of a decoded JSON body
+func (m Mapping) Apply(doc interface{}) (api.Event, error) {
+	var event api.Event
+	var err error
+	fields := []struct {
+		template string
+		value    *string
+	}{
+		{m.Title, &event.Title},
+		{m.Description, &event.Description},
+		{m.DedupKey, &event.DedupKey},
+		{m.Source, &event.Source},
+	}
+	for _, field := range fields {
+		if *field.value, err = render(doc, field.template); err != nil {
+			return event, err
+		}
+	}
+
+	severity, err := render(doc, m.Severity)
+	if err != nil {
+		return event, err
+	}
+	event.Severity = m.severity(severity)
+
+	status, err := render(doc, m.Status)
+	if err != nil {
+		return event, err
+	}
+	event.EventType = api.EventTrigger
+	if matchesAny(status, m.ResolveWhen) {
+		event.EventType = api.EventResolve
+	} else if matchesAny(status, m.AcknowledgeWhen) {
+		event.EventType = api.EventAcknowledge
+	}
+
+	if len(m.Details) > 0 {
+		event.Details = map[string]interface{}{}
+		for name, template := range m.Details {
+			value, err := render(doc, template)
+			if err != nil {
+				return event, err
+			}
+			event.Details[name] = value
+		}
+	}
+
+	return event, nil
+}
+
+func (m Mapping) severity(value string) incidents.Severity {
+	for key, severity := range m.Severities {
+		if strings.EqualFold(key, value) {
+			return severity
+		}
+	}
+	for _, severity := range []incidents.Severity{incidents.SeverityLow, incidents.SeverityMedium, incidents.SeverityHigh} {
+		if strings.EqualFold(string(severity), value) {
+			return severity
+		}
+	}
+	return incidents.SeverityLow
+}
+
+func matchesAny(value string, candidates []string) bool {
+	if value == "" {
+		return false
+	}
+	for _, candidate := range candidates {
+		if strings.EqualFold(value, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func VerifyMapping(m *Mapping) error {
+	if m == nil {
+		return errors.New("generic integrations need a mapping")
+	}
+	if m.Title == "" {
+		return errors.New("mapping title is required")
+	}
+
+	// rendering against an empty body surfaces template syntax errors
+	templates := []string{m.Title, m.Description, m.Severity, m.DedupKey, m.Source, m.Status}
+	for _, template := range m.Details {
+		templates = append(templates, template)
+	}
+	for _, template := range templates {
+		if _, err := render(nil, template); err != nil {
+			return err
+		}
+	}
+
+	for key, severity := range m.Severities {
+		if severity != incidents.SeverityLow && severity != incidents.SeverityMedium && severity != incidents.SeverityHigh {
+			return fmt.Errorf("severity for %q must be Low, Medium or High", key)
+		}
+	}
+	return nil
+}
+
+// grafanaLegacyAlert is the body of the webhook notifier of Grafana's
+// legacy alerting
+type grafanaLegacyAlert struct {
+	Title    string `json:"title"`
+	RuleId   int64  `json:"ruleId"`
+	RuleName string `json:"ruleName"`
+	RuleURL  string `json:"ruleUrl"`
+	State    string `json:"state"`
+	Message  string `json:"message"`
+}
+
+// grafanaToEvents reads Grafana alerting webhooks. Unified alerting sends
+// Alertmanager shaped groups, legacy alerting one alert per rule.
+func grafanaToEvents(body []byte) ([]api.Event, error) {
+	var webhook api.AlertmanagerWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, err
+	}
+	if len(webhook.Alerts) > 0 {
+		var events []api.Event
+		for _, alert := range webhook.Alerts {
+			event := alert.ToEvent(webhook)
+			event.DedupKey = "grafana:" + strings.TrimPrefix(event.DedupKey, "alertmanager:")
+			events = append(events, event)
+		}
+		return events, nil
+	}
+
+	var legacy grafanaLegacyAlert
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, err
+	}
+
+	event := api.Event{
+		Title:     legacy.Title,
+		Severity:  incidents.SeverityMedium,
+		DedupKey:  fmt.Sprintf("grafana:rule:%d", legacy.RuleId),
+		Source:    legacy.RuleName,
+		EventType: api.EventTrigger,
+		Details:   map[string]interface{}{"ruleUrl": legacy.RuleURL, "state": legacy.State},
+	}
+	if event.Title == "" {
+		event.Title = legacy.RuleName
+	}
+	event.Description = legacy.Message
+	if legacy.RuleURL != "" {
+		event.Description = strings.TrimSpace(event.Description + "\nLink: " + legacy.RuleURL)
+	}
+
+	switch legacy.State {
+	case "alerting", "no_data":
+	case "ok":
+		event.EventType = api.EventResolve
+	default:
+		// paused and pending rules are not worth a page
+		return nil, nil
+	}
+	return []api.Event{event}, nil
+}
+
+type sentryWebhook struct {
+	// integration platform webhooks
+	Action string `json:"action"`
+	Data   struct {
+		Issue       *sentryIssue `json:"issue"`
+		Event       *sentryEvent `json:"event"`
+		MetricAlert *struct {
+			Id    string `json:"id"`
+			Title string `json:"title"`
+		} `json:"metric_alert"`
+		DescriptionTitle string `json:"description_title"`
+		DescriptionText  string `json:"description_text"`
+		WebURL           string `json:"web_url"`
+	} `json:"data"`
+
+	// legacy webhooks plugin
+	Id          string       `json:"id"`
+	ProjectName string       `json:"project_name"`
+	Culprit     string       `json:"culprit"`
+	Level       string       `json:"level"`
+	URL         string       `json:"url"`
+	Message     string       `json:"message"`
+	Event       *sentryEvent `json:"event"`
+}
+
+type sentryIssue struct {
+	Id        string `json:"id"`
+	Title     string `json:"title"`
+	Culprit   string `json:"culprit"`
+	Level     string `json:"level"`
+	ShortId   string `json:"shortId"`
+	Permalink string `json:"permalink"`
+	Project   struct {
+		Slug string `json:"slug"`
+	} `json:"project"`
+}
+
+type sentryEvent struct {
+	Title       string `json:"title"`
+	Level       string `json:"level"`
+	IssueId     string `json:"issue_id"`
+	WebURL      string `json:"web_url"`
+	Culprit     string `json:"culprit"`
+	Environment string `json:"environment"`
+}
+
+var sentrySeverities = map[string]incidents.Severity{
+	"fatal":    incidents.SeverityHigh,
+	"error":    incidents.SeverityHigh,
+	"critical": incidents.SeverityHigh,
+	"warning":  incidents.SeverityMedium,
+	"info":     incidents.SeverityLow,
+	"debug":    incidents.SeverityLow,
+}
+
+// sentryToEvents reads Sentry issue, issue alert and metric alert webhooks
+// as well as the legacy webhooks plugin. Issues are deduplicated by id.
+func sentryToEvents(body []byte) ([]api.Event, error) {
+	var webhook sentryWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, err
+	}
+
+	event := api.Event{EventType: api.EventTrigger}
+	var level, culprit, link string
+
+	switch {
+	case webhook.Data.Issue != nil:
+		issue := webhook.Data.Issue
+		switch webhook.Action {
+		case "created", "unresolved":
+		case "resolved":
+			event.EventType = api.EventResolve
+		default:
+			// assignments and the like are not alerts
+			return nil, nil
+		}
+		event.Title = issue.Title
+		event.DedupKey = "sentry:issue:" + issue.Id
+		event.Source = issue.Project.Slug
+		level, culprit, link = issue.Level, issue.Culprit, issue.Permalink
+	case webhook.Data.Event != nil:
+		alert := webhook.Data.Event
+		event.Title = alert.Title
+		event.DedupKey = "sentry:issue:" + alert.IssueId
+		event.Source = alert.Environment
+		level, culprit, link = alert.Level, alert.Culprit, alert.WebURL
+	case webhook.Data.MetricAlert != nil:
+		event.Title = webhook.Data.DescriptionTitle
+		if event.Title == "" {
+			event.Title = webhook.Data.MetricAlert.Title
+		}
+		event.DedupKey = "sentry:metric:" + webhook.Data.MetricAlert.Id
+		event.Description = webhook.Data.DescriptionText
+		link = webhook.Data.WebURL
+		switch webhook.Action {
+		case "critical":
+			level = "critical"
+		case "warning":
+			level = "warning"
+		case "resolved":
+			event.EventType = api.EventResolve
+		}
+	case webhook.Id != "":
+		event.Title = webhook.Message
+		if webhook.Event != nil && webhook.Event.Title != "" {
+			event.Title = webhook.Event.Title
+		}
+		event.DedupKey = "sentry:issue:" + webhook.Id
+		event.Source = webhook.ProjectName
+		level, culprit, link = webhook.Level, webhook.Culprit, webhook.URL
+	default:
+		return nil, errors.New("unrecognised Sentry payload")
+	}
+
+	event.Severity = incidents.SeverityLow
+	if severity, ok := sentrySeverities[level]; ok {
+		event.Severity = severity
+	}
+
+	var description []string
+	if event.Description != "" {
+		description = append(description, event.Description)
+	}
+	if culprit != "" {
+		description = append(description, "Culprit: "+culprit)
+	}
+	if link != "" {
+		description = append(description, "Link: "+link)
+	}
+	event.Description = strings.Join(description, "\n")
+	event.Details = map[string]interface{}{"level": level, "link": link}
+
+	return []api.Event{event}, nil
+}
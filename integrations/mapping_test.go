@@ -0,0 +1,236 @@
+package integrations
+
+import (
+	"issue-reporting/api"
+	"issue-reporting/incidents"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestGenericMapping(t *testing.T) {
+	integration := Integration{Id: "int-1", Type: TypeGeneric, Mapping: &Mapping{
+		Title:       "{{ $.check.name }} is {{ $.state }}",
+		Description: "$.output",
+		Severity:    "$.check.priority",
+		DedupKey:    "check:{{ $.check.id }}",
+		Source:      "$.hosts[-1]",
+		Severities:  map[string]incidents.Severity{"P1": incidents.SeverityHigh},
+		Status:      "$.state",
+		ResolveWhen: []string{"up", "ok"},
+		Details:     map[string]string{"region": "$.check.tags.region"},
+	}}
+
+	body := []byte(`{"state": "down", "output": "timeout after 10s", "hosts": ["lb-1", "web-2"],
+		"check": {"id": 42, "name": "Homepage", "priority": "p1", "tags": {"region": "eu"}}}`)
+	events, err := ToEvents(integration, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("%d events, want 1", len(events))
+	}
+	want := api.Event{
+		Title:       "Homepage is down",
+		Description: "timeout after 10s",
+		Severity:    incidents.SeverityHigh,
+		EventType:   api.EventTrigger,
+		DedupKey:    "check:42",
+		Source:      "web-2",
+		Details:     map[string]interface{}{"region": "eu"},
+		Integration: "int-1",
+	}
+	got := events[0]
+	if got.Title != want.Title || got.Description != want.Description || got.Severity != want.Severity ||
+		got.EventType != want.EventType || got.DedupKey != want.DedupKey || got.Source != want.Source ||
+		got.Integration != want.Integration || got.Details["region"] != "eu" {
+		t.Errorf("event = %+v, want %+v", got, want)
+	}
+
+	events, err = ToEvents(integration, []byte(`{"state": "UP", "check": {"id": 42, "name": "Homepage"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events[0].EventType != api.EventResolve {
+		t.Errorf("event type %q, want %q", events[0].EventType, api.EventResolve)
+	}
+	if events[0].Severity != incidents.SeverityLow {
+		t.Errorf("missing severity = %q, want %q", events[0].Severity, incidents.SeverityLow)
+	}
+}
+
+func TestVerifyMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping *Mapping
+		wantErr bool
+	}{
+		{"nil", nil, true},
+		{"no title", &Mapping{Description: "$.text"}, true},
+		{"bad path", &Mapping{Title: "$..title"}, true},
+		{"unclosed template", &Mapping{Title: "{{ $.title"}, true},
+		{"bad detail", &Mapping{Title: "$.title", Details: map[string]string{"x": "{{ x }}"}}, true},
+		{"bad severity", &Mapping{Title: "$.title", Severities: map[string]incidents.Severity{"p1": "Critical"}}, true},
+		{"valid", &Mapping{Title: "{{ $.title }}", Severity: "$.level", Severities: map[string]incidents.Severity{"p1": incidents.SeverityHigh}}, false},
+	}
+	for _, tt := range tests {
+		if err := VerifyMapping(tt.mapping); (err != nil) != tt.wantErr {
+			t.Errorf("%s: VerifyMapping error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGrafanaPreset(t *testing.T) {
+	integration := Integration{Id: "int-1", Type: TypeGrafana}
+
+	events, err := ToEvents(integration, readFixture(t, "grafana_unified.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("%d events, want one per alert", len(events))
+	}
+	firing, resolved := events[0], events[1]
+	if firing.EventType != api.EventTrigger || firing.DedupKey != "grafana:9e1d7b3c0f2a5864" {
+		t.Errorf("firing alert: type %q, dedup key %q", firing.EventType, firing.DedupKey)
+	}
+	if firing.Title != "CPU above 90% on web-3" || firing.Severity != incidents.SeverityHigh || firing.Source != "web-3:9100" {
+		t.Errorf("firing alert: title %q, severity %q, source %q", firing.Title, firing.Severity, firing.Source)
+	}
+	if resolved.EventType != api.EventResolve || resolved.DedupKey != "grafana:4b0a6e2d8c1f3957" {
+		t.Errorf("resolved alert: type %q, dedup key %q", resolved.EventType, resolved.DedupKey)
+	}
+
+	events, err = ToEvents(integration, readFixture(t, "grafana_legacy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("%d legacy events, want 1", len(events))
+	}
+	legacy := events[0]
+	if legacy.Title != "[Alerting] Test notification" || legacy.DedupKey != "grafana:rule:17" || legacy.EventType != api.EventTrigger {
+		t.Errorf("legacy alert: title %q, dedup key %q, type %q", legacy.Title, legacy.DedupKey, legacy.EventType)
+	}
+	if !strings.Contains(legacy.Description, "Link: https://grafana.example.com/d/abc/dash?panelId=1") {
+		t.Errorf("legacy description %q has no rule link", legacy.Description)
+	}
+
+	ok := strings.Replace(string(readFixture(t, "grafana_legacy.json")), `"state": "alerting"`, `"state": "ok"`, 1)
+	events, err = ToEvents(integration, []byte(ok))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].EventType != api.EventResolve {
+		t.Errorf("legacy ok state: %+v, want a resolve", events)
+	}
+
+	paused := strings.Replace(string(readFixture(t, "grafana_legacy.json")), `"state": "alerting"`, `"state": "paused"`, 1)
+	events, err = ToEvents(integration, []byte(paused))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("legacy paused state: %+v, want nothing", events)
+	}
+}
+
+func TestSentryPreset(t *testing.T) {
+	integration := Integration{Id: "int-1", Type: TypeSentry}
+	tests := []struct {
+		fixture   string
+		title     string
+		dedupKey  string
+		severity  incidents.Severity
+		eventType api.EventType
+		contains  string
+	}{
+		{
+			fixture:   "sentry_issue.json",
+			title:     "TypeError: Cannot read properties of undefined (reading 'total')",
+			dedupKey:  "sentry:issue:1170820242",
+			severity:  incidents.SeverityHigh,
+			eventType: api.EventTrigger,
+			contains:  "Culprit: app/components/Cart in renderSummary",
+		},
+		{
+			fixture:   "sentry_event_alert.json",
+			title:     "OutOfMemoryError: Java heap space",
+			dedupKey:  "sentry:issue:1170820242",
+			severity:  incidents.SeverityHigh,
+			eventType: api.EventTrigger,
+			contains:  "Link: https://example.sentry.io/issues/1170820242/events/",
+		},
+		{
+			fixture:   "sentry_metric_alert.json",
+			title:     "Resolved: Error rate above 5%",
+			dedupKey:  "sentry:metric:4021",
+			severity:  incidents.SeverityLow,
+			eventType: api.EventResolve,
+			contains:  "5% errors in the last 10 minutes",
+		},
+		{
+			fixture:   "sentry_legacy.json",
+			title:     "Exception: This is an example Python exception",
+			dedupKey:  "sentry:issue:27379932",
+			severity:  incidents.SeverityMedium,
+			eventType: api.EventTrigger,
+			contains:  "Culprit: raven.scripts.runner in main",
+		},
+	}
+
+	for _, tt := range tests {
+		events, err := ToEvents(integration, readFixture(t, tt.fixture))
+		if err != nil {
+			t.Errorf("%s: %v", tt.fixture, err)
+			continue
+		}
+		if len(events) != 1 {
+			t.Errorf("%s: %d events, want 1", tt.fixture, len(events))
+			continue
+		}
+		event := events[0]
+		if event.Title != tt.title || event.DedupKey != tt.dedupKey || event.Severity != tt.severity || event.EventType != tt.eventType {
+			t.Errorf("%s: title %q, dedup key %q, severity %q, type %q", tt.fixture, event.Title, event.DedupKey, event.Severity, event.EventType)
+		}
+		if !strings.Contains(event.Description, tt.contains) {
+			t.Errorf("%s: description %q is missing %q", tt.fixture, event.Description, tt.contains)
+		}
+		if event.Integration != "int-1" {
+			t.Errorf("%s: integration %q", tt.fixture, event.Integration)
+		}
+	}
+
+	// the same issue resolved in Sentry resolves the incident
+	resolved := strings.Replace(string(readFixture(t, "sentry_issue.json")), `"action": "created"`, `"action": "resolved"`, 1)
+	events, err := ToEvents(integration, []byte(resolved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].EventType != api.EventResolve || events[0].DedupKey != "sentry:issue:1170820242" {
+		t.Errorf("resolved issue: %+v", events)
+	}
+
+	assigned := strings.Replace(string(readFixture(t, "sentry_issue.json")), `"action": "created"`, `"action": "assigned"`, 1)
+	events, err = ToEvents(integration, []byte(assigned))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("assigned issue: %+v, want nothing", events)
+	}
+
+	if _, err := ToEvents(integration, []byte(`{"hello": "world"}`)); err == nil {
+		t.Error("unrecognised payload was accepted")
+	}
+}
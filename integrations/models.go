@@ -0,0 +1,80 @@
+package integrations
+
+import (
+	"issue-reporting/incidents"
+	"time"
+)
+
+// Integration is an alert source of a team. Each has its own key, and its
+// type decides how incoming bodies are turned into incidents.
+type Integration struct {
+	Id        string      `json:"id"`
+	TeamId    string      `json:"teamId"`
+	Name      string      `json:"name"`
+	Type      Type        `json:"type"`
+	Key       string      `json:"key"`
+	Mapping   *Mapping    `json:"mapping,omitempty"`
+	Email     *EmailRules `json:"email,omitempty"`
+	Disabled  bool        `json:"disabled"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+type Type string
+
+const (
+	TypeAPI          Type = "api"
+	TypeGeneric      Type = "generic"
+	TypeGrafana      Type = "grafana"
+	TypeSentry       Type = "sentry"
+	TypeAlertmanager Type = "alertmanager"
+	TypePagerDuty    Type = "pagerduty"
+	TypeEmail        Type = "email"
+)
+
+var Types = []Type{TypeAPI, TypeGeneric, TypeGrafana, TypeSentry, TypeAlertmanager, TypePagerDuty, TypeEmail}
+
+// Mapping reads an incident out of any JSON body for generic integrations.
+// Each field is a template in which {{ $.path }} is replaced by the value at
+// that JSONPath; a field holding only a path such as $.alert.title is read
+// as is.
+type Mapping struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Severity    string `json:"severity"`
+	DedupKey    string `json:"dedupKey"`
+	Source      string `json:"source"`
+
+	// Severities maps rendered severity values (case-insensitive) onto
+	// incident severities. Values matching Low, Medium or High need no entry.
+	Severities map[string]incidents.Severity `json:"severities"`
+
+	// Status is rendered and compared to ResolveWhen and AcknowledgeWhen to
+	// pick the event type. Anything else triggers.
+	Status          string   `json:"status"`
+	ResolveWhen     []string `json:"resolveWhen"`
+	AcknowledgeWhen []string `json:"acknowledgeWhen"`
+
+	// Details are stored on the incident under their names
+	Details map[string]string `json:"details"`
+}
+
+// EmailRules read an incident out of an alert email. The subject becomes
+// the title and the text body the description; the rules are regular
+// expressions matched against the subject and then the body.
+type EmailRules struct {
+	// Severities are tried in order, the first matching pattern wins
+	Severities []SeverityRule `json:"severities"`
+
+	// DedupKey's first capture group, or whole match, is the dedup key
+	DedupKey string `json:"dedupKey"`
+
+	// Resolve and Acknowledge turn matching emails into those events
+	Resolve     string `json:"resolve"`
+	Acknowledge string `json:"acknowledge"`
+}
+
+type SeverityRule struct {
+	Pattern  string             `json:"pattern"`
+	Severity incidents.Severity `json:"severity"`
+}
@@ -0,0 +1,24 @@
+package integrations
+
+import (
+	"issue-reporting/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterRoutes(app *fiber.App) {
+	// senders such as Sentry cannot set headers, so the key can be in the path
+	app.Post("/webhooks", middleware.VerifyIntegration(), Receive)
+	app.Post("/webhooks/:key", middleware.VerifyIntegration(), Receive)
+
+	// the recipient address holds the integration key
+	app.Post("/inbound/email", ReceiveEmail)
+
+	integrations := app.Group("/integrations").Use(middleware.AuthMiddleware())
+	integrations.Post("/", CreateIntegration)
+	integrations.Get("/", GetIntegrations)
+	integrations.Get("/:id", GetIntegration)
+	integrations.Put("/:id", UpdateIntegration)
+	integrations.Post("/:id/key", RotateKey)
+	integrations.Delete("/:id", DeleteIntegration)
+}
@@ -3,14 +3,23 @@ package main
 import (
 	"issue-reporting/api"
 	"issue-reporting/auth"
+	"issue-reporting/changes"
 	"issue-reporting/cron"
 	"issue-reporting/database"
+	"issue-reporting/escalations"
+	"issue-reporting/handoffs"
+	"issue-reporting/heartbeats"
 	"issue-reporting/incidents"
+	"issue-reporting/integrations"
+	"issue-reporting/maintenance"
 	"issue-reporting/reports"
+	"issue-reporting/rules"
 	"issue-reporting/schedules"
+	"issue-reporting/syslog"
 	"issue-reporting/users"
 	"log"
 	"os"
+	"strings"
 
 	_ "issue-reporting/docs"
 
@@ -26,13 +35,27 @@ func main() {
 	if err := database.Connect(); err != nil {
 		log.Fatal(err)
 	}
+	if err := api.EnsureDedupIndex(); err != nil {
+		// open incidents already sharing a key keep the index from building
+		log.Println("Error creating dedup index:", err)
+	}
 
 	cron.StartNotifyAssignScheduler()
 	// cron.ReportGeneratorScheduler()
-	// cron.StartNotifyAcknowlegedScheduler()
+	cron.StartEscalationScheduler()
+	cron.StartCoverageGapScheduler()
+	cron.StartHandoffScheduler()
+	cron.StartHeartbeatScheduler()
+	cron.StartFlappingScheduler()
+	cron.StartMaintenanceScheduler()
+
+	syslog.Start()
 
 	port := os.Getenv("PORT")
-	app := fiber.New()
+	app := fiber.New(fiber.Config{
+		// batch log ingestion sends thousands of logs at once
+		BodyLimit: 16 * 1024 * 1024,
+	})
 	app.Use(recover.New())
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "https://roaring-biscotti-b91532.netlify.app, http://localhost:3000, http://localhost:3001",
@@ -41,9 +64,14 @@ func main() {
 		AllowCredentials: true,
 	}))
 	app.Use(logger.New(logger.Config{
-		Format:     "${cyan}[${time}] ${red}[${ip}] ${magenta}${bytesSent}bytes ${green}${latency} ${blue}${method} ${blue}${status} ${white}${path}\n",
+		Format:     "${cyan}[${time}] ${red}[${ip}] ${magenta}${bytesSent}bytes ${green}${latency} ${blue}${method} ${blue}${status} ${white}${safePath}\n",
 		TimeFormat: "02-Jan-2006",
 		TimeZone:   "UTC",
+		CustomTags: map[string]logger.LogFunc{
+			"safePath": func(output logger.Buffer, c *fiber.Ctx, data *logger.Data, extraParam string) (int, error) {
+				return output.WriteString(redactPath(c.Path()))
+			},
+		},
 	}))
 
 	auth.RegisterAuthRoutes(app)
@@ -52,6 +80,27 @@ func main() {
 	schedules.RegisterRoutes(app)
 	reports.RegisterRoutes(app)
 	api.RegisterRoutes(app)
+	escalations.RegisterRoutes(app)
+	handoffs.RegisterRoutes(app)
+	integrations.RegisterRoutes(app)
+	heartbeats.RegisterRoutes(app)
+	maintenance.RegisterRoutes(app)
+	rules.RegisterRoutes(app)
+	changes.RegisterRoutes(app)
 
 	app.Listen(":" + port)
 }
+
+// secretPaths are routes whose last segment is a credential on its own
+var secretPaths = []string{"/ical/", "/webhooks/"}
+
+// redactPath masks the final segment of secretPaths so tokens never end up
+// in the request log.
+func redactPath(path string) string {
+	for _, prefix := range secretPaths {
+		if strings.HasPrefix(path, prefix) && len(path) > len(prefix) {
+			return prefix + "****"
+		}
+	}
+	return path
+}
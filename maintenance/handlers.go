@@ -0,0 +1,148 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/utils"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func CreateWindow(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var window Window
+	if err := c.BodyParser(&window); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if err := VerifyWindow(window); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	window.Id = code
+	window.TeamId = user.TeamId
+	window.CreatedBy = user.Code
+	window.Cancelled = false
+	window.CancelledAt = nil
+	window.CreatedAt = time.Now()
+	window.UpdatedAt = time.Now()
+
+	_, err = database.InsertOne("maintenancewindows", window)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "maintenance window not created")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "maintenance window created",
+		"window":  window,
+	})
+}
+
+// GetWindows lists the team's maintenance windows, only those that have not
+// ended or been cancelled with upcoming=true
+func GetWindows(c *fiber.Ctx) error {
+	ctx := context.Background()
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter := bson.M{"teamid": user.TeamId}
+	if c.QueryBool("upcoming") {
+		filter["cancelled"] = false
+		filter["end"] = bson.M{"$gt": time.Now()}
+	}
+
+	cursor, err := database.Find("maintenancewindows", filter)
+	if err != nil {
+		return fmt.Errorf("error finding maintenance windows: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var windows []Window
+	if err := cursor.All(ctx, &windows); err != nil {
+		return fmt.Errorf("error decoding maintenance windows: %v", err)
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "maintenance windows data",
+		"windows": windows,
+	})
+}
+
+// CancelWindow ends a window straight away. Incidents it already
+// suppressed stay suppressed.
+func CancelWindow(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	now := time.Now()
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId, "cancelled": false}
+	update := bson.M{"$set": bson.M{"cancelled": true, "cancelledat": now, "updatedat": now}}
+
+	var window Window
+	err = database.FindOneAndUpdate("maintenancewindows", filter, update).Decode(&window)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusNotFound, "No maintenance window found")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "maintenance window cancelled",
+		"window":  &window,
+	})
+}
+
+func VerifyWindow(window Window) error {
+	if window.Name == "" {
+		return errors.New("maintenance window name is required")
+	}
+	if window.Start.IsZero() || window.End.IsZero() {
+		return errors.New("start and end are required")
+	}
+	if !window.End.After(window.Start) {
+		return errors.New("end must be after start")
+	}
+	if window.End.Before(time.Now()) {
+		return errors.New("maintenance window is already over")
+	}
+	for _, severity := range window.Severities {
+		if severity != incidents.SeverityLow && severity != incidents.SeverityMedium && severity != incidents.SeverityHigh {
+			return errors.New("severities must be Low, Medium or High")
+		}
+	}
+	return nil
+}
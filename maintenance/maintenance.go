@@ -0,0 +1,71 @@
+package maintenance
+
+import (
+	"context"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Covers reports whether an alert from source with the given severity at
+// time at falls in the window
+func (w Window) Covers(at time.Time, source string, severity incidents.Severity) bool {
+	if w.Cancelled || at.Before(w.Start) || !at.Before(w.End) {
+		return false
+	}
+	if len(w.Services) > 0 {
+		found := false
+		for _, service := range w.Services {
+			if strings.EqualFold(service, source) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(w.Severities) > 0 {
+		found := false
+		for _, s := range w.Severities {
+			if s == severity {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Active returns the team's window covering an alert, if any
+func Active(teamId string, at time.Time, source string, severity incidents.Severity) (*Window, error) {
+	ctx := context.Background()
+	filter := bson.M{
+		"teamid":    teamId,
+		"cancelled": false,
+		"start":     bson.M{"$lte": at},
+		"end":       bson.M{"$gt": at},
+	}
+	cursor, err := database.Find("maintenancewindows", filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var windows []Window
+	if err := cursor.All(ctx, &windows); err != nil {
+		return nil, err
+	}
+	for _, window := range windows {
+		if window.Covers(at, source, severity) {
+			return &window, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,25 @@
+package maintenance
+
+import (
+	"issue-reporting/incidents"
+	"time"
+)
+
+// Window is a planned maintenance during which ingested alerts still open
+// incidents but nobody is assigned or paged. Services and Severities narrow
+// the window down to alerts from those sources (the service or app an
+// event names) and of those severities, empty means all.
+type Window struct {
+	Id          string               `json:"id"`
+	TeamId      string               `json:"teamId"`
+	Name        string               `json:"name"`
+	Start       time.Time            `json:"start"`
+	End         time.Time            `json:"end"`
+	Services    []string             `json:"services"`
+	Severities  []incidents.Severity `json:"severities"`
+	CreatedBy   string               `json:"createdBy"`
+	Cancelled   bool                 `json:"cancelled"`
+	CancelledAt *time.Time           `json:"cancelledAt"`
+	CreatedAt   time.Time            `json:"created_at"`
+	UpdatedAt   time.Time            `json:"updated_at"`
+}
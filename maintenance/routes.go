@@ -0,0 +1,14 @@
+package maintenance
+
+import (
+	"issue-reporting/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterRoutes(app *fiber.App) {
+	maintenance := app.Group("/maintenance").Use(middleware.AuthMiddleware())
+	maintenance.Post("/", CreateWindow)
+	maintenance.Get("/", GetWindows)
+	maintenance.Post("/:id/cancel", CancelWindow)
+}
@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"encoding/json"
+	"errors"
 	"issue-reporting/database"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
@@ -32,24 +35,101 @@ const (
 	PushNotification Channel = "PushNotification"
 )
 
+// Integration is the part of an integration the middleware needs to
+// authenticate its key
+type Integration struct {
+	Id       string `json:"id"`
+	TeamId   string `json:"teamId"`
+	Key      string `json:"key"`
+	Disabled bool   `json:"disabled"`
+}
+
+// keyOwner finds the team an ingestion key belongs to. Integration keys are
+// tried first, then the team's own API key.
+func keyOwner(key string) (teamId string, integrationId string, err error) {
+	var integration Integration
+	err = database.FindOne("integrations", bson.M{"key": key, "disabled": false}).Decode(&integration)
+	if err == nil && integration.Key == key {
+		return integration.TeamId, integration.Id, nil
+	}
+
+	var team Team
+	err = database.FindOne("teams", bson.M{"apikey": key}).Decode(&team)
+	if err != nil {
+		return "", "", err
+	}
+	if team.APIKey != key {
+		return "", "", errors.New("invalid API key")
+	}
+	return team.TeamId, "", nil
+}
+
 func VerifyAPI() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		token := c.Get("Authorization")
+		// Alertmanager and most webhook senders can only send bearer tokens
+		token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
 		if token == "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Missing authentication token"})
 		}
 
-		var team Team
-		err := database.FindOne("teams", bson.M{"apikey": token}).Decode(&team)
+		teamId, integrationId, err := keyOwner(token)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Invalid API key"})
 		}
 
-		if team.APIKey != token {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Invalid API key"})
+		c.Locals("teamId", teamId)
+		c.Locals("integrationId", integrationId)
+		return c.Next()
+	}
+}
+
+// VerifyRoutingKey authenticates PagerDuty style events, which carry the
+// team's API key as routing_key in the JSON body instead of a header.
+func VerifyRoutingKey() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body struct {
+			RoutingKey string `json:"routing_key"`
+		}
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "invalid event", "message": "Event object is invalid"})
+		}
+		if body.RoutingKey == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Missing routing key"})
+		}
+
+		teamId, integrationId, err := keyOwner(body.RoutingKey)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Invalid routing key"})
+		}
+
+		c.Locals("teamId", teamId)
+		c.Locals("integrationId", integrationId)
+		return c.Next()
+	}
+}
+
+// VerifyIntegration authenticates webhooks by an integration key in the
+// path, for senders that cannot set headers, or in the Authorization header.
+// Team API keys are not accepted because the integration decides how the
+// body is read.
+func VerifyIntegration() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Params("key")
+		if key == "" {
+			key = strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+		}
+		if key == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Missing integration key"})
+		}
+
+		var integration Integration
+		err := database.FindOne("integrations", bson.M{"key": key, "disabled": false}).Decode(&integration)
+		if err != nil || integration.Key != key {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized", "message": "Invalid integration key"})
 		}
 
-		c.Locals("teamId", team.TeamId)
+		c.Locals("teamId", integration.TeamId)
+		c.Locals("integrationId", integration.Id)
 		return c.Next()
 	}
 }
@@ -0,0 +1,33 @@
+package notification
+
+import (
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"log"
+	"time"
+)
+
+// Page is a record of a user being paged about an incident
+type Page struct {
+	TeamId     string    `json:"teamId"`
+	UserCode   string    `json:"userCode"`
+	IncidentId string    `json:"incidentId"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// SendPage notifies a user about an incident and records the page so
+// on-call load can be reported on later.
+func SendPage(message string, user auth.User, incidentId string) {
+	page := Page{
+		TeamId:     user.TeamId,
+		UserCode:   user.Code,
+		IncidentId: incidentId,
+		CreatedAt:  time.Now(),
+	}
+	_, err := database.InsertOne("pages", page)
+	if err != nil {
+		log.Printf("Error recording page: %v", err)
+	}
+
+	SendNotification(message, user)
+}
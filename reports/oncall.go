@@ -0,0 +1,308 @@
+package reports
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/notification"
+	"issue-reporting/schedules"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Working hours are 09:00 to 18:00 on weekdays, nights run from 22:00 to
+// 07:00, both in the time zone of the report.
+const (
+	workStartHour  = 9
+	workEndHour    = 18
+	nightStartHour = 22
+	nightEndHour   = 7
+)
+
+// maxLoadDays caps the window a single load report can cover
+const maxLoadDays = 366
+
+// OnCallLoad is how much on-call work a user carried over one period
+type OnCallLoad struct {
+	PeriodStart        time.Time `json:"periodStart"`
+	PeriodEnd          time.Time `json:"periodEnd"`
+	UserCode           string    `json:"userCode"`
+	Name               string    `json:"name"`
+	Email              string    `json:"email"`
+	OnCallHours        float64   `json:"onCallHours"`
+	OutOfHoursHours    float64   `json:"outOfHoursHours"`
+	IncidentsAssigned  int       `json:"incidentsAssigned"`
+	PagesReceived      int       `json:"pagesReceived"`
+	NightInterruptions int       `json:"nightInterruptions"`
+}
+
+// OnCallReport computes the load of every team member for each period of
+// window. Periods are "week", "month" or, when empty, the whole window.
+func OnCallReport(teamId string, window schedules.TimeRange, period string, layer schedules.Layer, loc *time.Location) ([]OnCallLoad, error) {
+	ctx := context.Background()
+
+	cursor, err := database.Find("users", bson.M{"teamId": teamId})
+	if err != nil {
+		return nil, err
+	}
+	var members []auth.User
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, err
+	}
+
+	timeline, err := schedules.Timeline(teamId, "", layer, window)
+	if err != nil {
+		return nil, err
+	}
+
+	inWindow := bson.M{"$gte": window.Start.UTC(), "$lt": window.End.UTC()}
+	cursor, err = database.Find("incidents", bson.M{"teamid": teamId, "createdat": inWindow})
+	if err != nil {
+		return nil, err
+	}
+	var incidentList []incidents.Incident
+	if err := cursor.All(ctx, &incidentList); err != nil {
+		return nil, err
+	}
+
+	cursor, err = database.Find("pages", bson.M{"teamid": teamId, "createdat": inWindow})
+	if err != nil {
+		return nil, err
+	}
+	var pages []notification.Page
+	if err := cursor.All(ctx, &pages); err != nil {
+		return nil, err
+	}
+
+	var report []OnCallLoad
+	for _, p := range periods(window, period, loc) {
+		rows := make([]OnCallLoad, len(members))
+		loads := make(map[string]*OnCallLoad)
+		byCode := make(map[string]*OnCallLoad)
+		for i, member := range members {
+			rows[i] = OnCallLoad{PeriodStart: p.Start, PeriodEnd: p.End, UserCode: member.Code, Name: member.Name, Email: member.Email}
+			loads[member.Email] = &rows[i]
+			byCode[member.Code] = &rows[i]
+		}
+
+		for _, shift := range timeline {
+			load, ok := loads[shift.User.Email]
+			if !ok {
+				continue
+			}
+			r := clip(shift.Time, p)
+			if !r.End.After(r.Start) {
+				continue
+			}
+			total := r.End.Sub(r.Start)
+			load.OnCallHours += total.Hours()
+			load.OutOfHoursHours += (total - workingHours(r, loc)).Hours()
+		}
+
+		for _, incident := range incidentList {
+			if incident.CreatedAt.Before(p.Start) || !incident.CreatedAt.Before(p.End) {
+				continue
+			}
+			counted := make(map[string]bool)
+			for _, user := range incident.AssignedTo {
+				if load, ok := loads[user.Email]; ok && !counted[user.Email] {
+					load.IncidentsAssigned++
+					counted[user.Email] = true
+				}
+			}
+		}
+
+		for _, page := range pages {
+			if page.CreatedAt.Before(p.Start) || !page.CreatedAt.Before(p.End) {
+				continue
+			}
+			load, ok := byCode[page.UserCode]
+			if !ok {
+				continue
+			}
+			load.PagesReceived++
+			if hour := page.CreatedAt.In(loc).Hour(); hour >= nightStartHour || hour < nightEndHour {
+				load.NightInterruptions++
+			}
+		}
+
+		for _, row := range rows {
+			row.OnCallHours = roundHours(row.OnCallHours)
+			row.OutOfHoursHours = roundHours(row.OutOfHoursHours)
+			report = append(report, row)
+		}
+	}
+	return report, nil
+}
+
+// periods splits window into calendar weeks (starting Monday) or months in loc
+func periods(window schedules.TimeRange, period string, loc *time.Location) []schedules.TimeRange {
+	if period == "" {
+		return []schedules.TimeRange{window}
+	}
+
+	start := window.Start.In(loc)
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+	if period == "week" {
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	} else {
+		start = start.AddDate(0, 0, 1-start.Day())
+	}
+
+	var list []schedules.TimeRange
+	for start.Before(window.End) {
+		next := start.AddDate(0, 1, 0)
+		if period == "week" {
+			next = start.AddDate(0, 0, 7)
+		}
+		list = append(list, clip(schedules.TimeRange{Start: start, End: next}, window))
+		start = next
+	}
+	return list
+}
+
+func clip(r, window schedules.TimeRange) schedules.TimeRange {
+	if r.Start.Before(window.Start) {
+		r.Start = window.Start
+	}
+	if r.End.After(window.End) {
+		r.End = window.End
+	}
+	return r
+}
+
+// workingHours returns how much of r falls within working hours in loc
+func workingHours(r schedules.TimeRange, loc *time.Location) time.Duration {
+	var total time.Duration
+	day := r.Start.In(loc)
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+	for day.Before(r.End) {
+		if day.Weekday() != time.Saturday && day.Weekday() != time.Sunday {
+			open := time.Date(day.Year(), day.Month(), day.Day(), workStartHour, 0, 0, 0, loc)
+			close := time.Date(day.Year(), day.Month(), day.Day(), workEndHour, 0, 0, 0, loc)
+			overlap := clip(r, schedules.TimeRange{Start: open, End: close})
+			if overlap.End.After(overlap.Start) {
+				total += overlap.End.Sub(overlap.Start)
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return total
+}
+
+func roundHours(hours float64) float64 {
+	return float64(int64(hours*100+0.5)) / 100
+}
+
+// GetOnCallReport reports on-call load per user between the start and end
+// queries (default the last 30 days). The period query splits the report by
+// "week" or "month", tz sets the time zone for working and night hours, and
+// format=csv returns a CSV file instead of JSON.
+func GetOnCallReport(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	window := schedules.TimeRange{Start: time.Now().AddDate(0, 0, -30), End: time.Now()}
+	if start := c.Query("start"); start != "" {
+		window.Start, err = time.Parse(time.RFC3339, start)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid start timestamp")
+		}
+	}
+	if end := c.Query("end"); end != "" {
+		window.End, err = time.Parse(time.RFC3339, end)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid end timestamp")
+		}
+	}
+	if err := schedules.VerifyTimeRange(window); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+	if window.End.Sub(window.Start) > maxLoadDays*24*time.Hour {
+		return fiber.NewError(fiber.StatusBadRequest, "report window is limited to 366 days")
+	}
+
+	period := c.Query("period")
+	if period != "" && period != "week" && period != "month" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "period must be week or month",
+		})
+	}
+
+	loc, err := time.LoadLocation(c.Query("tz", "UTC"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "invalid time zone",
+		})
+	}
+
+	layer, err := schedules.VerifyLayer(schedules.Layer(c.Query("layer")))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	report, err := OnCallReport(user.TeamId, window, period, layer, loc)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong building the report")
+	}
+
+	if strings.EqualFold(c.Query("format"), "csv") {
+		var b strings.Builder
+		if err := writeOnCallCSV(&b, report); err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong building the report")
+		}
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="oncall-report.csv"`)
+		return c.Status(200).SendString(b.String())
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":  "on-call report",
+		"window":   window,
+		"timezone": loc.String(),
+		"report":   report,
+	})
+}
+
+func writeOnCallCSV(b *strings.Builder, report []OnCallLoad) error {
+	w := csv.NewWriter(b)
+	w.Write([]string{"period_start", "period_end", "user_code", "name", "email", "on_call_hours", "out_of_hours_hours", "incidents_assigned", "pages_received", "night_interruptions"})
+	for _, load := range report {
+		w.Write([]string{
+			load.PeriodStart.Format(time.RFC3339),
+			load.PeriodEnd.Format(time.RFC3339),
+			load.UserCode,
+			load.Name,
+			load.Email,
+			strconv.FormatFloat(load.OnCallHours, 'f', 2, 64),
+			strconv.FormatFloat(load.OutOfHoursHours, 'f', 2, 64),
+			fmt.Sprint(load.IncidentsAssigned),
+			fmt.Sprint(load.PagesReceived),
+			fmt.Sprint(load.NightInterruptions),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
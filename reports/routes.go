@@ -9,4 +9,5 @@ import (
 func RegisterRoutes(app *fiber.App) {
 	reports := app.Group("/reports").Use(middleware.AuthMiddleware())
 	reports.Get("/", GetReports)
+	reports.Get("/oncall", GetOnCallReport)
 }
@@ -0,0 +1,310 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/utils"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func CreateRule(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var rule Rule
+	if err := c.BodyParser(&rule); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if rule.Match == "" {
+		rule.Match = MatchAll
+	}
+	if err := VerifyRule(user.TeamId, rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	// new rules go last unless placed
+	if rule.Position == 0 {
+		existing, err := TeamRules(user.TeamId)
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+		}
+		rule.Position = 1
+		if len(existing) > 0 {
+			rule.Position = existing[len(existing)-1].Position + 1
+		}
+	}
+
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	rule.Id = code
+	rule.TeamId = user.TeamId
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+
+	_, err = database.InsertOne("eventrules", rule)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "event rule not created")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "event rule created",
+		"rule":    rule,
+	})
+}
+
+func GetRules(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	rules, err := TeamRules(user.TeamId)
+	if err != nil {
+		return fmt.Errorf("error finding event rules: %v", err)
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "event rules data",
+		"rules":   rules,
+	})
+}
+
+func GetRule(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var rule Rule
+	err = database.FindOne("eventrules", bson.M{"id": c.Params("id"), "teamid": user.TeamId}).Decode(&rule)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusNotFound, "No event rule found")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "event rule data",
+		"rule":    &rule,
+	})
+}
+
+func UpdateRule(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var body Rule
+	if err := c.BodyParser(&body); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if body.Match == "" {
+		body.Match = MatchAll
+	}
+	if err := VerifyRule(user.TeamId, body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+	set := bson.M{
+		"name":       body.Name,
+		"match":      body.Match,
+		"conditions": body.Conditions,
+		"actions":    body.Actions,
+		"continue":   body.Continue,
+		"disabled":   body.Disabled,
+		"updatedat":  time.Now(),
+	}
+	if body.Position != 0 {
+		set["position"] = body.Position
+	}
+
+	var rule Rule
+	err = database.FindOneAndUpdate("eventrules", filter, bson.M{"$set": set}).Decode(&rule)
+	if err != nil {
+		return fiber.NewError(fiber.StatusNoContent, err.Error())
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "event rule updated",
+		"rule":    &rule,
+	})
+}
+
+func DeleteRule(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+
+	var rule Rule
+	err = database.FindOne("eventrules", filter).Decode(&rule)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusExpectationFailed, "No event rule found")
+	}
+
+	_, err = database.InsertOne("deletedeventrules", rule)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	_, err = database.DeleteOne("eventrules", filter)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "event rule deleted",
+		"rule":    &rule,
+	})
+}
+
+// TestRules is a dry run of the team's rules against a sample alert. Nothing
+// is created, the response shows the matching rules and the actions they
+// would take.
+func TestRules(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var alert Alert
+	if err := c.BodyParser(&alert); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	rules, err := TeamRules(user.TeamId)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	result := Apply(rules, alert)
+
+	var matched []fiber.Map
+	for _, id := range result.Matched {
+		for _, rule := range rules {
+			if rule.Id == id {
+				matched = append(matched, fiber.Map{"id": rule.Id, "name": rule.Name, "position": rule.Position})
+			}
+		}
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "event rules evaluated",
+		"matched": matched,
+		"actions": result.Actions,
+	})
+}
+
+var fields = []string{"title", "description", "severity", "source"}
+
+// VerifyRule checks a rule, including that its assignee and escalation
+// policy belong to the team
+func VerifyRule(teamId string, rule Rule) error {
+	if rule.Name == "" {
+		return errors.New("rule name is required")
+	}
+	if rule.Position < 0 {
+		return errors.New("position cannot be negative")
+	}
+	if rule.Match != MatchAll && rule.Match != MatchAny {
+		return errors.New("match must be all or any")
+	}
+
+	for i, condition := range rule.Conditions {
+		known := strings.HasPrefix(condition.Field, "details.") && len(condition.Field) > len("details.")
+		for _, field := range fields {
+			if condition.Field == field {
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Errorf("condition %d: field must be one of %s or details.<name>", i+1, strings.Join(fields, ", "))
+		}
+
+		switch condition.Operator {
+		case OperatorEquals, OperatorNotEquals, OperatorContains, OperatorNotContains, OperatorExists:
+		case OperatorMatches:
+			if _, err := regexp.Compile(condition.Value); err != nil {
+				return fmt.Errorf("condition %d: %v", i+1, err)
+			}
+		default:
+			return fmt.Errorf("condition %d: unknown operator %q", i+1, condition.Operator)
+		}
+	}
+
+	actions := rule.Actions
+	if actions.Severity == "" && actions.Assignee == "" && actions.EscalationPolicy == "" && len(actions.Tags) == 0 && !actions.Suppress && !actions.Drop {
+		return errors.New("rule needs at least one action")
+	}
+	switch actions.Severity {
+	case "", incidents.SeverityLow, incidents.SeverityMedium, incidents.SeverityHigh:
+	default:
+		return errors.New("severity must be Low, Medium or High")
+	}
+	if actions.Assignee != "" {
+		var user auth.User
+		if err := database.FindOne("users", bson.M{"code": actions.Assignee, "teamId": teamId}).Decode(&user); err != nil {
+			return fmt.Errorf("no user %q in the team", actions.Assignee)
+		}
+	}
+	if actions.EscalationPolicy != "" {
+		if err := database.FindOne("escalationpolicies", bson.M{"id": actions.EscalationPolicy, "teamid": teamId}).Err(); err != nil {
+			return fmt.Errorf("no escalation policy %q in the team", actions.EscalationPolicy)
+		}
+	}
+	return nil
+}
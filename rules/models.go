@@ -0,0 +1,77 @@
+package rules
+
+import (
+	"issue-reporting/incidents"
+	"time"
+)
+
+// Rule shapes inbound alerts before they become incidents. Rules run in
+// Position order and the first one whose conditions hold applies, unless it
+// sets Continue to let later rules apply as well.
+type Rule struct {
+	Id         string      `json:"id"`
+	TeamId     string      `json:"teamId"`
+	Name       string      `json:"name"`
+	Position   int         `json:"position"`
+	Match      MatchType   `json:"match"`
+	Conditions []Condition `json:"conditions"`
+	Actions    Actions     `json:"actions"`
+	Continue   bool        `json:"continue"`
+	Disabled   bool        `json:"disabled"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// MatchType says whether all conditions of a rule must hold or any one
+type MatchType string
+
+const (
+	MatchAll MatchType = "all"
+	MatchAny MatchType = "any"
+)
+
+// Condition tests one field of an alert: title, description, severity,
+// source, or details.<name> for a custom field
+type Condition struct {
+	Field    string   `json:"field"`
+	Operator Operator `json:"operator"`
+	Value    string   `json:"value"`
+}
+
+type Operator string
+
+const (
+	OperatorEquals      Operator = "equals"
+	OperatorNotEquals   Operator = "not_equals"
+	OperatorContains    Operator = "contains"
+	OperatorNotContains Operator = "not_contains"
+	OperatorMatches     Operator = "matches"
+	OperatorExists      Operator = "exists"
+)
+
+// Actions change what the alert turns into. Assignee is a user code and
+// EscalationPolicy a policy id of the team. Drop discards the alert,
+// Suppress opens the incident without assigning or paging anyone.
+type Actions struct {
+	Severity         incidents.Severity `json:"severity,omitempty"`
+	Assignee         string             `json:"assignee,omitempty"`
+	EscalationPolicy string             `json:"escalation_policy,omitempty"`
+	Tags             []string           `json:"tags,omitempty"`
+	Suppress         bool               `json:"suppress,omitempty"`
+	Drop             bool               `json:"drop,omitempty"`
+}
+
+// Alert is what rules look at
+type Alert struct {
+	Title       string                 `json:"title"`
+	Description string                 `json:"description"`
+	Severity    incidents.Severity     `json:"severity"`
+	Source      string                 `json:"source"`
+	Details     map[string]interface{} `json:"details"`
+}
+
+// Result is what the team's rules made of an alert
+type Result struct {
+	Matched []string `json:"matched"`
+	Actions Actions  `json:"actions"`
+}
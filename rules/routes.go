@@ -0,0 +1,17 @@
+package rules
+
+import (
+	"issue-reporting/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterRoutes(app *fiber.App) {
+	rules := app.Group("/eventrules").Use(middleware.AuthMiddleware())
+	rules.Post("/", CreateRule)
+	rules.Get("/", GetRules)
+	rules.Post("/test", TestRules)
+	rules.Get("/:id", GetRule)
+	rules.Put("/:id", UpdateRule)
+	rules.Delete("/:id", DeleteRule)
+}
@@ -0,0 +1,180 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"issue-reporting/database"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TeamRules returns the team's rules in evaluation order
+func TeamRules(teamId string) ([]Rule, error) {
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "createdat", Value: 1}})
+	cursor, err := database.GetDatabase().Database("IssueReporting").Collection("eventrules").Find(ctx, bson.M{"teamid": teamId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rules []Rule
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Evaluate runs the team's enabled rules over an alert
+func Evaluate(teamId string, alert Alert) (*Result, error) {
+	rules, err := TeamRules(teamId)
+	if err != nil {
+		return nil, err
+	}
+	return Apply(rules, alert), nil
+}
+
+// Apply runs rules in order over an alert. Later rules override the
+// severity, assignee and policy set by earlier ones, tags add up, and
+// suppress and drop stick.
+func Apply(rules []Rule, alert Alert) *Result {
+	result := &Result{Matched: []string{}}
+	patterns := compilePatterns(rules)
+	for _, rule := range rules {
+		if rule.Disabled || !rule.matches(alert, patterns) {
+			continue
+		}
+		result.Matched = append(result.Matched, rule.Id)
+
+		actions := rule.Actions
+		if actions.Severity != "" {
+			result.Actions.Severity = actions.Severity
+		}
+		if actions.Assignee != "" {
+			result.Actions.Assignee = actions.Assignee
+		}
+		if actions.EscalationPolicy != "" {
+			result.Actions.EscalationPolicy = actions.EscalationPolicy
+		}
+		result.Actions.Tags = appendMissing(result.Actions.Tags, actions.Tags...)
+		result.Actions.Suppress = result.Actions.Suppress || actions.Suppress
+		result.Actions.Drop = result.Actions.Drop || actions.Drop
+
+		if !rule.Continue {
+			break
+		}
+	}
+	return result
+}
+
+// Matches reports whether the rule's conditions hold for the alert. A rule
+// without conditions matches everything.
+func (r Rule) Matches(alert Alert) bool {
+	return r.matches(alert, compilePatterns([]Rule{r}))
+}
+
+func (r Rule) matches(alert Alert, patterns map[string]*regexp.Regexp) bool {
+	if len(r.Conditions) == 0 {
+		return true
+	}
+	for _, condition := range r.Conditions {
+		holds := condition.holds(alert, patterns)
+		if r.Match == MatchAny && holds {
+			return true
+		}
+		if r.Match != MatchAny && !holds {
+			return false
+		}
+	}
+	return r.Match != MatchAny
+}
+
+// compilePatterns compiles the patterns of the rules' matches conditions
+// once, invalid ones map to nil and never match
+func compilePatterns(rules []Rule) map[string]*regexp.Regexp {
+	patterns := map[string]*regexp.Regexp{}
+	for _, rule := range rules {
+		for _, condition := range rule.Conditions {
+			if condition.Operator != OperatorMatches {
+				continue
+			}
+			if _, ok := patterns[condition.Value]; ok {
+				continue
+			}
+			patterns[condition.Value], _ = regexp.Compile(condition.Value)
+		}
+	}
+	return patterns
+}
+
+func (c Condition) holds(alert Alert, patterns map[string]*regexp.Regexp) bool {
+	value, found := fieldValue(alert, c.Field)
+	switch c.Operator {
+	case OperatorExists:
+		return found && value != ""
+	case OperatorEquals:
+		return strings.EqualFold(value, c.Value)
+	case OperatorNotEquals:
+		return !strings.EqualFold(value, c.Value)
+	case OperatorContains:
+		return strings.Contains(strings.ToLower(value), strings.ToLower(c.Value))
+	case OperatorNotContains:
+		return !strings.Contains(strings.ToLower(value), strings.ToLower(c.Value))
+	case OperatorMatches:
+		re := patterns[c.Value]
+		return re != nil && re.MatchString(value)
+	}
+	return false
+}
+
+// fieldValue reads a field of the alert as text
+func fieldValue(alert Alert, field string) (string, bool) {
+	switch field {
+	case "title":
+		return alert.Title, true
+	case "description":
+		return alert.Description, true
+	case "severity":
+		return string(alert.Severity), true
+	case "source":
+		return alert.Source, true
+	}
+
+	name, ok := strings.CutPrefix(field, "details.")
+	if !ok {
+		return "", false
+	}
+	value, found := alert.Details[name]
+	switch v := value.(type) {
+	case nil:
+		return "", found
+	case string:
+		return v, true
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v), true
+		}
+		return string(data), true
+	}
+}
+
+func appendMissing(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
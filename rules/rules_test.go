@@ -0,0 +1,149 @@
+package rules
+
+import (
+	"issue-reporting/incidents"
+	"reflect"
+	"testing"
+)
+
+var testAlert = Alert{
+	Title:       "Disk almost full on db-1",
+	Description: "Only 3% left on /var/lib/postgres",
+	Severity:    incidents.SeverityHigh,
+	Source:      "Postgres",
+	Details: map[string]interface{}{
+		"env":     "production",
+		"replica": 2,
+		"labels":  map[string]interface{}{"team": "data"},
+		"empty":   nil,
+	},
+}
+
+func TestConditionHolds(t *testing.T) {
+	tests := []struct {
+		field    string
+		operator Operator
+		value    string
+		want     bool
+	}{
+		{"title", OperatorEquals, "disk almost full on DB-1", true},
+		{"title", OperatorEquals, "Disk almost full", false},
+		{"source", OperatorNotEquals, "mysql", true},
+		{"source", OperatorNotEquals, "postgres", false},
+		{"description", OperatorContains, "POSTGRES", true},
+		{"description", OperatorNotContains, "mysql", true},
+		{"description", OperatorNotContains, "postgres", false},
+		{"severity", OperatorEquals, "high", true},
+		{"title", OperatorMatches, `db-\d+$`, true},
+		{"title", OperatorMatches, `^db-\d+`, false},
+		{"title", OperatorMatches, `(`, false},
+		{"details.env", OperatorEquals, "Production", true},
+		{"details.replica", OperatorEquals, "2", true},
+		{"details.labels", OperatorContains, `"team":"data"`, true},
+		{"details.env", OperatorExists, "", true},
+		{"details.empty", OperatorExists, "", false},
+		{"details.missing", OperatorExists, "", false},
+		{"details.missing", OperatorNotEquals, "x", true},
+		{"unknown", OperatorExists, "", false},
+		{"title", Operator("starts_with"), "Disk", false},
+	}
+	for _, tt := range tests {
+		condition := Condition{Field: tt.field, Operator: tt.operator, Value: tt.value}
+		if got := condition.holds(testAlert, compilePatterns([]Rule{{Conditions: []Condition{condition}}})); got != tt.want {
+			t.Errorf("%s %s %q = %v, want %v", tt.field, tt.operator, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	holds := Condition{Field: "source", Operator: OperatorEquals, Value: "postgres"}
+	fails := Condition{Field: "source", Operator: OperatorEquals, Value: "mysql"}
+
+	tests := []struct {
+		name string
+		rule Rule
+		want bool
+	}{
+		{"no conditions", Rule{}, true},
+		{"all hold", Rule{Match: MatchAll, Conditions: []Condition{holds, holds}}, true},
+		{"all with one failing", Rule{Match: MatchAll, Conditions: []Condition{holds, fails}}, false},
+		{"match defaults to all", Rule{Conditions: []Condition{holds, fails}}, false},
+		{"any with one holding", Rule{Match: MatchAny, Conditions: []Condition{fails, holds}}, true},
+		{"any with none holding", Rule{Match: MatchAny, Conditions: []Condition{fails, fails}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.Matches(testAlert); got != tt.want {
+			t.Errorf("%s: Matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	postgres := []Condition{{Field: "source", Operator: OperatorEquals, Value: "postgres"}}
+	production := []Condition{{Field: "details.env", Operator: OperatorEquals, Value: "production"}}
+	mysql := []Condition{{Field: "source", Operator: OperatorEquals, Value: "mysql"}}
+
+	tests := []struct {
+		name  string
+		rules []Rule
+		want  Result
+	}{
+		{
+			name:  "no rules",
+			rules: nil,
+			want:  Result{Matched: []string{}},
+		},
+		{
+			name: "first match stops",
+			rules: []Rule{
+				{Id: "skip", Conditions: mysql, Actions: Actions{Severity: incidents.SeverityLow}},
+				{Id: "first", Conditions: postgres, Actions: Actions{Severity: incidents.SeverityHigh, Tags: []string{"db"}}},
+				{Id: "second", Conditions: production, Actions: Actions{Tags: []string{"prod"}}},
+			},
+			want: Result{Matched: []string{"first"}, Actions: Actions{Severity: incidents.SeverityHigh, Tags: []string{"db"}}},
+		},
+		{
+			name: "continue lets later rules override and add tags",
+			rules: []Rule{
+				{Id: "first", Conditions: postgres, Continue: true, Actions: Actions{Severity: incidents.SeverityHigh, Assignee: "abc", Tags: []string{"db"}}},
+				{Id: "second", Conditions: production, Actions: Actions{Severity: incidents.SeverityMedium, EscalationPolicy: "policy", Tags: []string{"db", "prod"}}},
+			},
+			want: Result{Matched: []string{"first", "second"}, Actions: Actions{
+				Severity:         incidents.SeverityMedium,
+				Assignee:         "abc",
+				EscalationPolicy: "policy",
+				Tags:             []string{"db", "prod"},
+			}},
+		},
+		{
+			name: "disabled rules are skipped",
+			rules: []Rule{
+				{Id: "off", Disabled: true, Actions: Actions{Drop: true}},
+				{Id: "on", Conditions: postgres, Actions: Actions{Tags: []string{"db"}}},
+			},
+			want: Result{Matched: []string{"on"}, Actions: Actions{Tags: []string{"db"}}},
+		},
+		{
+			name: "suppress and drop stick",
+			rules: []Rule{
+				{Id: "suppress", Continue: true, Actions: Actions{Suppress: true}},
+				{Id: "drop", Conditions: production, Continue: true, Actions: Actions{Drop: true}},
+				{Id: "neither", Conditions: postgres, Actions: Actions{Severity: incidents.SeverityLow}},
+			},
+			want: Result{Matched: []string{"suppress", "drop", "neither"}, Actions: Actions{Severity: incidents.SeverityLow, Suppress: true, Drop: true}},
+		},
+		{
+			name: "invalid pattern never matches",
+			rules: []Rule{
+				{Id: "broken", Conditions: []Condition{{Field: "title", Operator: OperatorMatches, Value: "("}}, Actions: Actions{Drop: true}},
+				{Id: "pattern", Conditions: []Condition{{Field: "title", Operator: OperatorMatches, Value: `(?i)^disk`}}, Actions: Actions{Tags: []string{"disk"}}},
+			},
+			want: Result{Matched: []string{"pattern"}, Actions: Actions{Tags: []string{"disk"}}},
+		},
+	}
+	for _, tt := range tests {
+		if got := Apply(tt.rules, testAlert); !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s: Apply = %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
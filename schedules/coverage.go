@@ -0,0 +1,162 @@
+package schedules
+
+import (
+	"context"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/notification"
+	"issue-reporting/users"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Gaps returns the parts of window where nobody is on call for one layer of
+// a named schedule, after explicit entries, overrides, rotations and time
+// off are resolved.
+func Gaps(teamId string, name string, layer Layer, window TimeRange) ([]TimeRange, error) {
+	timeline, err := Timeline(teamId, name, layer, window)
+	if err != nil {
+		return nil, err
+	}
+
+	covered := make([]TimeRange, 0, len(timeline))
+	for _, entry := range timeline {
+		covered = append(covered, entry.Time)
+	}
+	return uncovered(window, covered), nil
+}
+
+// uncovered subtracts the covered ranges from window
+func uncovered(window TimeRange, covered []TimeRange) []TimeRange {
+	sort.Slice(covered, func(i, j int) bool {
+		return covered[i].Start.Before(covered[j].Start)
+	})
+
+	var gaps []TimeRange
+	cursor := window.Start
+	for _, r := range covered {
+		if !r.End.After(cursor) {
+			continue
+		}
+		if r.Start.After(cursor) {
+			end := r.Start
+			if end.After(window.End) {
+				end = window.End
+			}
+			gaps = append(gaps, TimeRange{Start: cursor, End: end})
+		}
+		cursor = r.End
+		if !cursor.Before(window.End) {
+			break
+		}
+	}
+	if cursor.Before(window.End) {
+		gaps = append(gaps, TimeRange{Start: cursor, End: window.End})
+	}
+	return gaps
+}
+
+// GetCoverageGaps lists uncovered windows over the next days (default 14)
+// for the primary layer, or the layer and schedule given in the query.
+func GetCoverageGaps(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	days := 14
+	if daysStr := c.Query("days"); daysStr != "" {
+		days, err = strconv.Atoi(daysStr)
+		if err != nil || days <= 0 || days > 365 {
+			return fiber.NewError(fiber.StatusBadRequest, "days must be between 1 and 365")
+		}
+	}
+
+	layer, err := VerifyLayer(Layer(c.Query("layer")))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	window := TimeRange{Start: time.Now(), End: time.Now().AddDate(0, 0, days)}
+	gaps, err := Gaps(user.TeamId, c.Query("schedule"), layer, window)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting coverage")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "coverage gaps",
+		"window":  window,
+		"gaps":    gaps,
+	})
+}
+
+type coverageAlert struct {
+	TeamId    string
+	Gap       TimeRange
+	CreatedAt time.Time
+}
+
+// AlertCoverageGaps warns the Admins and Leads of every team about primary
+// on-call gaps starting within the next days. Each gap is reported once.
+func AlertCoverageGaps(days int) {
+	ctx := context.Background()
+	cursor, err := database.Find("teams", bson.M{})
+	if err != nil {
+		log.Printf("Error finding teams: %v", err)
+		return
+	}
+	var teams []auth.Team
+	if err := cursor.All(ctx, &teams); err != nil {
+		log.Printf("Error decoding teams: %v", err)
+		return
+	}
+
+	window := TimeRange{Start: time.Now(), End: time.Now().AddDate(0, 0, days)}
+	for _, team := range teams {
+		gaps, err := Gaps(team.TeamId, "", Primary, window)
+		if err != nil {
+			log.Printf("Error finding coverage gaps for team %s: %v", team.TeamId, err)
+			continue
+		}
+
+		for _, gap := range gaps {
+			// gaps that are already running start "now" on every run, so a
+			// gap counts as reported when an earlier alert contains its start
+			filter := bson.M{"teamid": team.TeamId, "gap.start": bson.M{"$lte": gap.Start}, "gap.end": bson.M{"$gt": gap.Start}}
+			if err := database.FindOne("coveragealerts", filter).Err(); err == nil {
+				continue
+			}
+
+			leads, err := users.WithRoles(team.TeamId, auth.Admin, auth.Lead)
+			if err != nil {
+				log.Printf("Error finding team leads for team %s: %v", team.TeamId, err)
+				break
+			}
+
+			message := fmt.Sprintf("On-call coverage gap: nobody is on call\nFrom: %s\nTo: %s", gap.Start.Format(time.RFC1123), gap.End.Format(time.RFC1123))
+			if !gap.End.Before(window.End) {
+				message = fmt.Sprintf("On-call coverage gap: nobody is on call from %s onwards", gap.Start.Format(time.RFC1123))
+			}
+			for _, lead := range leads {
+				notification.SendNotification(message, lead)
+			}
+
+			_, err = database.InsertOne("coveragealerts", coverageAlert{TeamId: team.TeamId, Gap: gap, CreatedAt: time.Now()})
+			if err != nil {
+				log.Printf("Error recording coverage alert: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package schedules
+
+import (
+	"context"
+	"issue-reporting/database"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestUncovered(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2024, 3, 1, hour, 0, 0, 0, time.UTC)
+	}
+	window := TimeRange{Start: at(0), End: at(12)}
+
+	tests := []struct {
+		name    string
+		covered []TimeRange
+		want    []TimeRange
+	}{
+		{"nothing covered", nil, []TimeRange{window}},
+		{"fully covered", []TimeRange{{Start: at(0), End: at(12)}}, nil},
+		{"adjacent ranges", []TimeRange{{Start: at(6), End: at(12)}, {Start: at(0), End: at(6)}}, nil},
+		{"hole in the middle", []TimeRange{{Start: at(0), End: at(4)}, {Start: at(6), End: at(12)}}, []TimeRange{{Start: at(4), End: at(6)}}},
+		{"overlapping ranges", []TimeRange{{Start: at(0), End: at(8)}, {Start: at(2), End: at(5)}}, []TimeRange{{Start: at(8), End: at(12)}}},
+		{"ranges past the window", []TimeRange{{Start: at(3), End: at(20)}}, []TimeRange{{Start: at(0), End: at(3)}}},
+	}
+	for _, tt := range tests {
+		if got := uncovered(window, tt.covered); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: uncovered = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGapsFollowTimeOff(t *testing.T) {
+	window := testWindow()
+	a, _ := seedTeams(t, window)
+	t.Cleanup(func() {
+		database.GetDatabase().Database("IssueReporting").Collection("timeoff").DeleteMany(context.Background(), bson.M{"teamid": a.id})
+	})
+
+	gaps, err := Gaps(a.id, "primary", Primary, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gaps) != 0 {
+		t.Fatalf("gaps %v before time off, want none", gaps)
+	}
+
+	// on leave for two hours with nobody to cover
+	leave := TimeRange{Start: window.Start.Add(3 * time.Hour), End: window.Start.Add(5 * time.Hour)}
+	if _, err := database.InsertOne("timeoff", TimeOff{Id: "test-leave", TeamId: a.id, User: a.user, Time: leave, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	gaps, err = Gaps(a.id, "primary", Primary, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gaps) != 1 || !gaps[0].Start.Equal(leave.Start) || !gaps[0].End.Equal(leave.End) {
+		t.Errorf("gaps %v, want only the time off %v", gaps, leave)
+	}
+}
@@ -0,0 +1,251 @@
+package schedules
+
+import (
+	"context"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/utils"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CreateFeed issues a feed token for the caller's own shifts, or for the
+// whole team when the body asks for scope "team".
+func CreateFeed(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var body struct {
+		Scope string
+	}
+	if err := c.BodyParser(&body); err != nil && len(c.Body()) > 0 {
+		return err
+	}
+
+	token, err := utils.GenerateRandomCode(32)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	feed := FeedToken{
+		Token:     token,
+		TeamId:    user.TeamId,
+		UserCode:  user.Code,
+		CreatedBy: user.Code,
+		CreatedAt: time.Now(),
+	}
+	if strings.EqualFold(body.Scope, "team") {
+		feed.UserCode = ""
+	}
+
+	_, err = database.InsertOne("feedtokens", feed)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "feed not created")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "feed created",
+		"feed":    feed,
+		"url":     "/ical/" + feed.Token + ".ics",
+	})
+}
+
+func GetFeeds(c *fiber.Ctx) error {
+	ctx := context.Background()
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	cursor, err := database.Find("feedtokens", bson.M{"teamid": user.TeamId, "revoked": false})
+	if err != nil {
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting feeds")
+	}
+	defer cursor.Close(ctx)
+
+	var feeds []FeedToken
+	if err := cursor.All(ctx, &feeds); err != nil {
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting feeds")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "all feeds",
+		"feeds":   feeds,
+	})
+}
+
+func RevokeFeed(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter := bson.M{"token": c.Params("token"), "teamid": user.TeamId, "revoked": false}
+	update := bson.M{"$set": bson.M{"revoked": true, "revokedat": time.Now()}}
+
+	var feed FeedToken
+	err = database.FindOneAndUpdate("feedtokens", filter, update).Decode(&feed)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusExpectationFailed, "No feed found")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "feed revoked",
+		"feed":    &feed,
+	})
+}
+
+// ServeFeed renders the shifts from 30 days ago to 90 days ahead as an ICS
+// calendar. It is authenticated by the feed token in the path only, so
+// calendar apps can subscribe to it.
+func ServeFeed(c *fiber.Ctx) error {
+	token := strings.TrimSuffix(c.Params("token"), ".ics")
+
+	var feed FeedToken
+	err := database.FindOne("feedtokens", bson.M{"token": token, "revoked": false}).Decode(&feed)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("feed not found")
+	}
+
+	start := time.Now().AddDate(0, 0, -30)
+	end := time.Now().AddDate(0, 0, 90)
+	entries, err := SchedulesWithinRange(feed.TeamId, "", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting schedules")
+	}
+
+	var shifts []Schedule
+	for _, entry := range entries {
+		if feed.UserCode != "" && entry.User.Code != feed.UserCode {
+			continue
+		}
+		shifts = append(shifts, entry)
+	}
+
+	name := "Team on-call"
+	if feed.UserCode != "" {
+		name = "My on-call shifts"
+	}
+
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `inline; filename="oncall.ics"`)
+	return c.Status(200).SendString(RenderICS(name, shifts))
+}
+
+// ImportSchedules creates schedule entries from the VEVENTs of an ICS file
+// sent as the request body. Each event is assigned to the team member
+// whose email is its first attendee, or to the userCode query parameter.
+// Every event is validated on its own and the response lists the ones that
+// were skipped.
+func ImportSchedules(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	layer, err := VerifyLayer(Layer(c.Query("layer")))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+	scheduleName := c.Query("scheduleName")
+
+	events, err := ParseICS(c.Body())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "invalid calendar: " + err.Error(),
+		})
+	}
+
+	var created []string
+	var skipped []fiber.Map
+	for _, event := range events {
+		scheduleId, err := importEvent(event, user.TeamId, c.Query("userCode"), scheduleName, layer)
+		if err != nil {
+			skipped = append(skipped, fiber.Map{"uid": event.UID, "summary": event.Summary, "error": err.Error()})
+			continue
+		}
+		created = append(created, scheduleId)
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": fmt.Sprintf("%d schedules imported, %d skipped", len(created), len(skipped)),
+		"created": created,
+		"skipped": skipped,
+	})
+}
+
+func importEvent(event CalendarEvent, teamId, fallbackUser, scheduleName string, layer Layer) (string, error) {
+	timeRange := TimeRange{Start: event.Start, End: event.End}
+	if err := VerifyTimeRange(timeRange); err != nil {
+		return "", err
+	}
+
+	if timeRange.Start.Before(time.Now()) {
+		return "", fmt.Errorf("start timestamp in the past")
+	}
+
+	filter := bson.M{"code": fallbackUser, "teamId": teamId}
+	if len(event.Attendees) > 0 {
+		filter = bson.M{"email": event.Attendees[0], "teamId": teamId}
+	}
+	var user auth.User
+	err := database.FindOne("users", filter).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", fmt.Errorf("no user found")
+		}
+		return "", err
+	}
+
+	timeOff, err := TimeOffDuring(teamId, user.Code, timeRange)
+	if err != nil {
+		return "", err
+	}
+	if timeOff != nil {
+		return "", fmt.Errorf("%s is on time off", user.Name)
+	}
+
+	existingSchedule, err := ScheduledAt(teamId, scheduleName, layer, timeRange.Start.Format(time.RFC3339), timeRange.End.Format(time.RFC3339))
+	if err != nil {
+		return "", err
+	}
+	if existingSchedule != nil {
+		return "", fmt.Errorf("schedule already exists within this time range")
+	}
+
+	schedule := Schedule{User: user, Time: timeRange, ScheduleName: scheduleName, Layer: layer}
+	result, err := database.InsertOne("schedules", schedule)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
@@ -16,14 +16,31 @@ import (
 )
 
 func CreateSchedules(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var caller auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&caller)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
 	var body struct {
-		TimeRange TimeRange
+		TimeRange    TimeRange
+		ScheduleName string
+		Layer        Layer
 	}
 	if err := c.BodyParser(&body); err != nil {
 		return err
 	}
 	userCode := c.Params("userCode")
 
+	layer, err := VerifyLayer(body.Layer)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
 	if body.TimeRange.Start.Before(time.Now()) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error":   "Bad Request",
@@ -31,7 +48,7 @@ func CreateSchedules(c *fiber.Ctx) error {
 		})
 	}
 
-	err := VerifyTimeRange(body.TimeRange)
+	err = VerifyTimeRange(body.TimeRange)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error":   "Bad Request",
@@ -39,7 +56,7 @@ func CreateSchedules(c *fiber.Ctx) error {
 		})
 	}
 
-	existingSchedule, err := ScheduledAt(body.TimeRange.Start.Format(time.RFC3339), body.TimeRange.End.Format(time.RFC3339))
+	existingSchedule, err := ScheduledAt(caller.TeamId, body.ScheduleName, layer, body.TimeRange.Start.Format(time.RFC3339), body.TimeRange.End.Format(time.RFC3339))
 	if err != nil {
 		log.Println(err)
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -54,8 +71,9 @@ func CreateSchedules(c *fiber.Ctx) error {
 		})
 	}
 
+	// only members of the caller's team can be scheduled
 	var user auth.User
-	err = database.FindOne("users", bson.M{"code": userCode}).Decode(&user)
+	err = database.FindOne("users", bson.M{"code": userCode, "teamId": caller.TeamId}).Decode(&user)
 	if err != nil {
 		log.Println(err, userCode)
 		if err == mongo.ErrNoDocuments {
@@ -64,9 +82,28 @@ func CreateSchedules(c *fiber.Ctx) error {
 				"message": "no user found",
 			})
 		}
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
 	}
 
-	schedule := Schedule{User: user, Time: body.TimeRange}
+	// scheduling someone into their time off needs ?force=true
+	var warning string
+	timeOff, err := TimeOffDuring(user.TeamId, user.Code, body.TimeRange)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if timeOff != nil {
+		warning = fmt.Sprintf("%s is on time off from %s to %s", user.Name, timeOff.Time.Start.Format(time.RFC3339), timeOff.Time.End.Format(time.RFC3339))
+		if !c.QueryBool("force") {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Bad Request",
+				"message": warning,
+				"timeOff": timeOff,
+			})
+		}
+	}
+
+	schedule := Schedule{User: user, Time: body.TimeRange, ScheduleName: body.ScheduleName, Layer: layer}
 
 	result, err := database.InsertOne("schedules", schedule)
 	if err != nil {
@@ -75,13 +112,25 @@ func CreateSchedules(c *fiber.Ctx) error {
 
 	insertedID := result.InsertedID.(primitive.ObjectID).Hex()
 
-	return c.Status(200).JSON(fiber.Map{
+	response := fiber.Map{
 		"message":    "schedule created",
 		"scheduleId": insertedID,
-	})
+	}
+	if warning != "" {
+		response["warning"] = warning
+	}
+	return c.Status(200).JSON(response)
 }
 
-func ScheduledAt(startTimestamp, endTimestamp string) (*Schedule, error) {
+// ScheduledAt returns an entry of the team's named schedule layer that
+// overlaps the given range, if any.
+func ScheduledAt(teamId string, name string, layer Layer, startTimestamp, endTimestamp string) (*Schedule, error) {
+	return scheduledAtExcept(teamId, name, layer, startTimestamp, endTimestamp, primitive.NilObjectID)
+}
+
+// scheduledAtExcept is ScheduledAt ignoring one entry, so an entry being
+// moved is not reported as overlapping itself.
+func scheduledAtExcept(teamId string, name string, layer Layer, startTimestamp, endTimestamp string, except primitive.ObjectID) (*Schedule, error) {
 	startTime, err := time.Parse(time.RFC3339, startTimestamp)
 	if err != nil {
 		return nil, errors.New("start timestamp is not in a valid format")
@@ -92,12 +141,16 @@ func ScheduledAt(startTimestamp, endTimestamp string) (*Schedule, error) {
 		return nil, errors.New("end timestamp is not in a valid format")
 	}
 
-	overlappingFilter := bson.M{
+	overlappingFilter := layerFilter(bson.M{
+		"user.teamId": teamId,
 		"$or": []bson.M{
 			bson.M{"time.start": bson.M{"$lt": endTime}, "time.end": bson.M{"$gt": startTime}},
 			bson.M{"time.start": bson.M{"$lt": endTime}, "time.end": endTime},
 			bson.M{"time.start": startTime, "time.end": bson.M{"$gt": startTime}},
 		},
+	}, name, layer)
+	if !except.IsZero() {
+		overlappingFilter["_id"] = bson.M{"$ne": except}
 	}
 
 	var schedule Schedule
@@ -112,7 +165,9 @@ func ScheduledAt(startTimestamp, endTimestamp string) (*Schedule, error) {
 	return &schedule, nil
 }
 
-func SchedulesWithinRange(startTimestamp, endTimestamp string) ([]Schedule, error) {
+// SchedulesWithinRange lists the team's entries that start and end within
+// the given range. An empty name matches every schedule.
+func SchedulesWithinRange(teamId string, name string, startTimestamp, endTimestamp string) ([]Schedule, error) {
 	ctx := context.Background()
 	startTime, err := time.Parse(time.RFC3339, startTimestamp)
 	if err != nil {
@@ -125,11 +180,15 @@ func SchedulesWithinRange(startTimestamp, endTimestamp string) ([]Schedule, erro
 	}
 
 	withinRangeFilter := bson.M{
+		"user.teamId": teamId,
 		"$and": []bson.M{
 			bson.M{"time.start": bson.M{"$gte": startTime}},
 			bson.M{"time.end": bson.M{"$lte": endTime}},
 		},
 	}
+	if name != "" {
+		withinRangeFilter["schedulename"] = name
+	}
 
 	cursor, err := database.Find("schedules", withinRangeFilter)
 	if err != nil {
@@ -149,25 +208,105 @@ func SchedulesWithinRange(startTimestamp, endTimestamp string) ([]Schedule, erro
 	return schedules, nil
 }
 
+// Scheduled resolves who is on the primary layer of any of the team's
+// schedules at timestamp.
 func Scheduled(timestamp time.Time, teamId string) (*Schedule, error) {
-	filter := bson.M{
+	return ScheduledLayer(timestamp, teamId, "", Primary)
+}
+
+// ScheduledLayer resolves who is on call for one layer of a named schedule.
+// Overrides take precedence, then explicit schedule entries, then rotations.
+// Users on time off are replaced by their backup or passed over for the next
+// source. An empty name matches every schedule of the team.
+func ScheduledLayer(timestamp time.Time, teamId string, name string, layer Layer) (*Schedule, error) {
+	override, err := overrideScheduled(timestamp, teamId, name, layer)
+	if err != nil {
+		return nil, err
+	}
+	if override != nil {
+		schedule, err := available(override, teamId, timestamp)
+		if err != nil || schedule != nil {
+			return schedule, err
+		}
+	}
+
+	filter := layerFilter(bson.M{
 		"user.teamId": teamId,
 		"$or": []bson.M{
 			{"time.start": bson.M{"$lt": timestamp.UTC()}, "time.end": bson.M{"$gt": timestamp.UTC()}},
 			{"time.start": bson.M{"$lt": timestamp.UTC()}, "time.end": timestamp.UTC()},
 			{"time.start": timestamp.UTC(), "time.end": bson.M{"$gt": timestamp.UTC()}},
 		},
-	}
+	}, name, layer)
 
+	// explicit entries are the materialized shifts and win over rotations
 	var schedule Schedule
-	err := database.FindOne("schedules", filter).Decode(&schedule)
+	err = database.FindOne("schedules", filter).Decode(&schedule)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, nil
+			return rotationScheduled(timestamp, teamId, name, layer)
 		}
 		return nil, err
 	}
-	return &schedule, nil
+
+	covered, err := available(&schedule, teamId, timestamp)
+	if err != nil || covered != nil {
+		return covered, err
+	}
+	return rotationScheduled(timestamp, teamId, name, layer)
+}
+
+// OnCall resolves every layer of a named schedule at timestamp. Layers with
+// nobody on call are left out.
+func OnCall(timestamp time.Time, teamId string, name string) (map[Layer]*Schedule, error) {
+	layers := map[Layer]*Schedule{}
+	for _, layer := range Layers {
+		schedule, err := ScheduledLayer(timestamp, teamId, name, layer)
+		if err != nil {
+			return nil, err
+		}
+		if schedule != nil {
+			layers[layer] = schedule
+		}
+	}
+	return layers, nil
+}
+
+// Responder is who gets paged for a new incident: the primary on-call,
+// or the secondary when the primary layer has nobody.
+func Responder(timestamp time.Time, teamId string) (*Schedule, error) {
+	schedule, err := ScheduledLayer(timestamp, teamId, "", Primary)
+	if err != nil || schedule != nil {
+		return schedule, err
+	}
+	return ScheduledLayer(timestamp, teamId, "", Secondary)
+}
+
+func VerifyLayer(layer Layer) (Layer, error) {
+	if layer == "" {
+		return Primary, nil
+	}
+	for _, known := range Layers {
+		if layer == known {
+			return layer, nil
+		}
+	}
+	return "", fmt.Errorf("unknown layer %q", layer)
+}
+
+// layerFilter narrows a query to one layer of a named schedule. Entries
+// created before layers existed count as primary, and an empty name
+// matches every schedule.
+func layerFilter(filter bson.M, name string, layer Layer) bson.M {
+	if layer == "" || layer == Primary {
+		filter["layer"] = bson.M{"$in": []interface{}{nil, "", Primary}}
+	} else {
+		filter["layer"] = layer
+	}
+	if name != "" {
+		filter["schedulename"] = name
+	}
+	return filter
 }
 
 func VerifyTimeRange(timeRange TimeRange) error {
@@ -195,14 +334,15 @@ func GetScheduledNow(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
 	}
 
-	schedule, err := Scheduled(time.Now(), user.TeamId)
+	layers, err := OnCall(time.Now(), user.TeamId, c.Query("schedule"))
 	if err != nil {
 		log.Printf("Error starting cronjob1: %v", err)
 	}
 
 	return c.Status(200).JSON(fiber.Map{
 		"message":  "schedule data",
-		"schedule": schedule,
+		"schedule": layers[Primary],
+		"layers":   layers,
 	})
 }
 
@@ -232,14 +372,22 @@ func GetScheduledAt(c *fiber.Ctx) error {
 }
 
 func ListByTimeRange(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
 	var body struct {
-		TimeRange TimeRange
+		TimeRange    TimeRange
+		ScheduleName string
 	}
 	if err := c.BodyParser(&body); err != nil {
 		return err
 	}
 
-	schedules, err := SchedulesWithinRange(body.TimeRange.Start.Format(time.RFC3339), body.TimeRange.End.Format(time.RFC3339))
+	schedules, err := SchedulesWithinRange(user.TeamId, body.ScheduleName, body.TimeRange.Start.Format(time.RFC3339), body.TimeRange.End.Format(time.RFC3339))
 	if err != nil {
 		return err
 	}
@@ -251,6 +399,13 @@ func ListByTimeRange(c *fiber.Ctx) error {
 }
 
 func DeleteSchedule(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
 	id := c.Params("id")
 	var schedule Schedule
 
@@ -259,7 +414,7 @@ func DeleteSchedule(c *fiber.Ctx) error {
 		return err
 	}
 
-	err = database.FindOne("schedules", bson.M{"_id": objID}).Decode(&schedule)
+	err = database.FindOne("schedules", bson.M{"_id": objID, "user.teamId": user.TeamId}).Decode(&schedule)
 	if err != nil && err != mongo.ErrNoDocuments {
 		log.Println(err)
 		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
@@ -275,7 +430,7 @@ func DeleteSchedule(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusExpectationFailed, "schedule not created")
 	}
 
-	_, err = database.DeleteOne("schedules", bson.M{"_id": objID})
+	_, err = database.DeleteOne("schedules", bson.M{"_id": objID, "user.teamId": user.TeamId})
 	if err != nil && err != mongo.ErrNoDocuments {
 		log.Println(err)
 		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
@@ -292,23 +447,61 @@ func DeleteSchedule(c *fiber.Ctx) error {
 }
 
 func UpdateSchedules(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
 	var scheduleUpdate TimeRange
 	if err := c.BodyParser(&scheduleUpdate); err != nil {
 		log.Println(err)
 		return err
 	}
 
+	if err := VerifyTimeRange(scheduleUpdate); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "invalid time range",
+		})
+	}
+
 	scheduleCode := c.Params("id")
 	objID, err := primitive.ObjectIDFromHex(scheduleCode)
 	if err != nil {
 		return err
 	}
 
-	filter := bson.M{"_id": objID}
-
-	update := bson.M{"$set": scheduleUpdate}
+	filter := bson.M{"_id": objID, "user.teamId": user.TeamId}
 
 	var schedule Schedule
+	err = database.FindOne("schedules", filter).Decode(&schedule)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusExpectationFailed, "No schedule found")
+	}
+
+	existingSchedule, err := scheduledAtExcept(user.TeamId, schedule.ScheduleName, schedule.Layer, scheduleUpdate.Start.Format(time.RFC3339), scheduleUpdate.End.Format(time.RFC3339), objID)
+	if err != nil {
+		log.Println(err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "invalid time range",
+		})
+	}
+	if existingSchedule != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "schedule already exists within this time range",
+		})
+	}
+
+	update := bson.M{"$set": bson.M{"time.start": scheduleUpdate.Start, "time.end": scheduleUpdate.End}}
+
 	err = database.FindOneAndUpdate("schedules", filter, update).Decode(&schedule)
 	if err != nil {
 		fmt.Println("Error:", err)
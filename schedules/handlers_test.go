@@ -0,0 +1,206 @@
+package schedules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/utils"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var connectOnce sync.Once
+var connectErr error
+
+// testTeam is a throwaway team with one member and one schedule entry.
+type testTeam struct {
+	id       string
+	user     auth.User
+	schedule primitive.ObjectID
+}
+
+// seedTeams connects to the database in MONGODB and creates two teams
+// whose schedule entries cover exactly the same range, so any query that
+// forgets the team filter returns the wrong team's entry.
+func seedTeams(t *testing.T, window TimeRange) (testTeam, testTeam) {
+	t.Helper()
+	if os.Getenv("MONGODB") == "" {
+		t.Skip("MONGODB is not set")
+	}
+	connectOnce.Do(func() { connectErr = database.Connect() })
+	if connectErr != nil {
+		t.Fatal(connectErr)
+	}
+
+	seed := func() testTeam {
+		id, err := utils.GenerateRandomCode(6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		team := testTeam{id: "test-" + id}
+		team.user = auth.User{Name: "Tester " + id, Email: id + "@schedules.test", TeamId: team.id, Code: id}
+		if _, err := database.InsertOne("users", team.user); err != nil {
+			t.Fatal(err)
+		}
+		result, err := database.InsertOne("schedules", Schedule{User: team.user, Time: window, ScheduleName: "primary", Layer: Primary})
+		if err != nil {
+			t.Fatal(err)
+		}
+		team.schedule = result.InsertedID.(primitive.ObjectID)
+
+		t.Cleanup(func() {
+			db := database.GetDatabase().Database("IssueReporting")
+			db.Collection("users").DeleteMany(context.Background(), bson.M{"teamId": team.id})
+			db.Collection("schedules").DeleteMany(context.Background(), bson.M{"user.teamId": team.id})
+			db.Collection("deletedschedules").DeleteMany(context.Background(), bson.M{"user.teamId": team.id})
+		})
+		return team
+	}
+	return seed(), seed()
+}
+
+// request runs one handler as the given user and returns the status code.
+func request(t *testing.T, as auth.User, method, route, path string, handler fiber.Handler, body interface{}) int {
+	t.Helper()
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("email", as.Email)
+		return c.Next()
+	})
+	app.Add(method, route, handler)
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func testWindow() TimeRange {
+	start := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	return TimeRange{Start: start, End: start.Add(8 * time.Hour)}
+}
+
+func TestScheduledAtIsolatesTeams(t *testing.T) {
+	window := testWindow()
+	a, b := seedTeams(t, window)
+
+	for _, team := range []testTeam{a, b} {
+		schedule, err := ScheduledAt(team.id, "primary", Primary, window.Start.Format(time.RFC3339), window.End.Format(time.RFC3339))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if schedule == nil || schedule.User.Code != team.user.Code {
+			t.Errorf("ScheduledAt(%s) = %+v, want %s's entry", team.id, schedule, team.user.Code)
+		}
+	}
+
+	schedule, err := ScheduledAt("test-nobody", "primary", Primary, window.Start.Format(time.RFC3339), window.End.Format(time.RFC3339))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedule != nil {
+		t.Errorf("ScheduledAt for an unknown team = %+v, want nil", schedule)
+	}
+}
+
+func TestSchedulesWithinRangeIsolatesTeams(t *testing.T) {
+	window := testWindow()
+	a, b := seedTeams(t, window)
+
+	for _, team := range []testTeam{a, b} {
+		schedules, err := SchedulesWithinRange(team.id, "", window.Start.Add(-time.Hour).Format(time.RFC3339), window.End.Add(time.Hour).Format(time.RFC3339))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(schedules) != 1 || schedules[0].User.Code != team.user.Code {
+			t.Errorf("SchedulesWithinRange(%s) = %+v, want only %s's entry", team.id, schedules, team.user.Code)
+		}
+	}
+}
+
+func TestCreateSchedulesIsolatesTeams(t *testing.T) {
+	window := testWindow()
+	a, b := seedTeams(t, window)
+	later := TimeRange{Start: window.End.Add(time.Hour), End: window.End.Add(2 * time.Hour)}
+
+	body := fiber.Map{"TimeRange": later, "ScheduleName": "primary"}
+	if status := request(t, a.user, "POST", "/:userCode", "/"+b.user.Code, CreateSchedules, body); status != fiber.StatusBadRequest {
+		t.Errorf("scheduling another team's user: status %d, want %d", status, fiber.StatusBadRequest)
+	}
+
+	// b's entry covers the same range, which must not block a
+	if _, err := database.DeleteOne("schedules", bson.M{"_id": a.schedule}); err != nil {
+		t.Fatal(err)
+	}
+	body = fiber.Map{"TimeRange": window, "ScheduleName": "primary"}
+	if status := request(t, a.user, "POST", "/:userCode", "/"+a.user.Code, CreateSchedules, body); status != fiber.StatusOK {
+		t.Errorf("scheduling over another team's entry: status %d, want %d", status, fiber.StatusOK)
+	}
+}
+
+func TestDeleteScheduleIsolatesTeams(t *testing.T) {
+	a, b := seedTeams(t, testWindow())
+
+	if status := request(t, a.user, "DELETE", "/:id", "/"+b.schedule.Hex(), DeleteSchedule, nil); status != fiber.StatusExpectationFailed {
+		t.Errorf("deleting another team's entry: status %d, want %d", status, fiber.StatusExpectationFailed)
+	}
+	if err := database.FindOne("schedules", bson.M{"_id": b.schedule}).Err(); err != nil {
+		t.Errorf("other team's entry is gone: %v", err)
+	}
+
+	if status := request(t, a.user, "DELETE", "/:id", "/"+a.schedule.Hex(), DeleteSchedule, nil); status != fiber.StatusOK {
+		t.Errorf("deleting own entry: status %d, want %d", status, fiber.StatusOK)
+	}
+}
+
+func TestUpdateSchedulesIsolatesTeams(t *testing.T) {
+	window := testWindow()
+	a, b := seedTeams(t, window)
+	later := TimeRange{Start: window.End.Add(time.Hour), End: window.End.Add(2 * time.Hour)}
+
+	if status := request(t, a.user, "PUT", "/:id", "/"+b.schedule.Hex(), UpdateSchedules, later); status == fiber.StatusOK {
+		t.Errorf("updating another team's entry succeeded")
+	}
+	var schedule Schedule
+	if err := database.FindOne("schedules", bson.M{"_id": b.schedule}).Decode(&schedule); err != nil {
+		t.Fatal(err)
+	}
+	if !schedule.Time.Start.Equal(window.Start) {
+		t.Errorf("other team's entry moved to %s", schedule.Time.Start)
+	}
+
+	// moving b's entry onto a second b entry overlaps, moving it onto a's
+	// range does not
+	if _, err := database.InsertOne("schedules", Schedule{User: b.user, Time: later, ScheduleName: "primary", Layer: Primary}); err != nil {
+		t.Fatal(err)
+	}
+	if status := request(t, b.user, "PUT", "/:id", "/"+b.schedule.Hex(), UpdateSchedules, later); status != fiber.StatusBadRequest {
+		t.Errorf("overlapping own entry: status %d, want %d", status, fiber.StatusBadRequest)
+	}
+	shifted := TimeRange{Start: window.Start.Add(time.Hour), End: window.End.Add(-time.Hour)}
+	if status := request(t, b.user, "PUT", "/:id", "/"+b.schedule.Hex(), UpdateSchedules, shifted); status != fiber.StatusOK {
+		t.Errorf("moving over another team's entry: status %d, want %d", status, fiber.StatusOK)
+	}
+	if err := database.FindOne("schedules", bson.M{"_id": b.schedule}).Decode(&schedule); err != nil {
+		t.Fatal(err)
+	}
+	if !schedule.Time.Start.Equal(shifted.Start) || !schedule.Time.End.Equal(shifted.End) {
+		t.Errorf("entry time = %+v, want %+v", schedule.Time, shifted)
+	}
+}
@@ -0,0 +1,210 @@
+package schedules
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const icsTimeFormat = "20060102T150405Z"
+
+// CalendarEvent is the part of an iCalendar VEVENT needed to build a schedule
+type CalendarEvent struct {
+	UID       string
+	Summary   string
+	Attendees []string
+	Start     time.Time
+	End       time.Time
+}
+
+// RenderICS renders schedule entries as an iCalendar document
+func RenderICS(name string, entries []Schedule) string {
+	var b strings.Builder
+	now := time.Now().UTC().Format(icsTimeFormat)
+
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//IAOS//On-call Schedules//EN")
+	writeICSLine(&b, "CALSCALE:GREGORIAN")
+	writeICSLine(&b, "METHOD:PUBLISH")
+	writeICSLine(&b, "X-WR-CALNAME:"+escapeICSText(name))
+
+	for _, entry := range entries {
+		summary := "On call: " + entry.User.Name
+		if entry.Layer != "" && entry.Layer != Primary {
+			summary += " (" + string(entry.Layer) + ")"
+		}
+		if entry.ScheduleName != "" {
+			summary += " - " + entry.ScheduleName
+		}
+
+		writeICSLine(&b, "BEGIN:VEVENT")
+		writeICSLine(&b, fmt.Sprintf("UID:%s-%s-%d@iaos", entry.User.Code, entry.Layer, entry.Time.Start.Unix()))
+		writeICSLine(&b, "DTSTAMP:"+now)
+		writeICSLine(&b, "DTSTART:"+entry.Time.Start.UTC().Format(icsTimeFormat))
+		writeICSLine(&b, "DTEND:"+entry.Time.End.UTC().Format(icsTimeFormat))
+		writeICSLine(&b, "SUMMARY:"+escapeICSText(summary))
+		if entry.User.Email != "" {
+			writeICSLine(&b, fmt.Sprintf("ATTENDEE;CN=%s:mailto:%s", escapeICSParam(entry.User.Name), entry.User.Email))
+		}
+		writeICSLine(&b, "TRANSP:TRANSPARENT")
+		writeICSLine(&b, "END:VEVENT")
+	}
+
+	writeICSLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// writeICSLine terminates a content line with CRLF, folding it at 75 octets
+func writeICSLine(b *strings.Builder, line string) {
+	for len(line) > 75 {
+		cut := 75
+		// never split a multi-byte character
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+func escapeICSText(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	return replacer.Replace(text)
+}
+
+func escapeICSParam(text string) string {
+	if strings.ContainsAny(text, ";:,") {
+		return `"` + strings.ReplaceAll(text, `"`, "") + `"`
+	}
+	return text
+}
+
+func unescapeICSText(text string) string {
+	replacer := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return replacer.Replace(text)
+}
+
+// ParseICS reads the VEVENTs out of an iCalendar document
+func ParseICS(data []byte) ([]CalendarEvent, error) {
+	lines, err := unfoldICS(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []CalendarEvent
+	var current *CalendarEvent
+	for _, line := range lines {
+		name, params, value, ok := splitICSLine(line)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			current = &CalendarEvent{}
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			if current == nil {
+				return nil, errors.New("END:VEVENT without BEGIN:VEVENT")
+			}
+			events = append(events, *current)
+			current = nil
+		case current == nil:
+			continue
+		case name == "UID":
+			current.UID = value
+		case name == "SUMMARY":
+			current.Summary = unescapeICSText(value)
+		case name == "ATTENDEE":
+			email := value
+			if strings.HasPrefix(strings.ToLower(email), "mailto:") {
+				email = email[len("mailto:"):]
+			}
+			current.Attendees = append(current.Attendees, email)
+		case name == "DTSTART":
+			current.Start, err = parseICSTime(value, params)
+			if err != nil {
+				return nil, fmt.Errorf("event %s: %v", current.UID, err)
+			}
+		case name == "DTEND":
+			current.End, err = parseICSTime(value, params)
+			if err != nil {
+				return nil, fmt.Errorf("event %s: %v", current.UID, err)
+			}
+		}
+	}
+
+	if current != nil {
+		return nil, errors.New("unterminated VEVENT")
+	}
+	return events, nil
+}
+
+func unfoldICS(data []byte) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// splitICSLine splits "NAME;PARAM=x:value" into its parts. Colons inside
+// quoted parameter values do not end the parameters.
+func splitICSLine(line string) (string, map[string]string, string, bool) {
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", nil, "", false
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	params := map[string]string{}
+	for _, param := range parts[1:] {
+		if key, value, ok := strings.Cut(param, "="); ok {
+			params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:], true
+}
+
+func parseICSTime(value string, params map[string]string) (time.Time, error) {
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		return time.ParseInLocation("20060102", value, icsLocation(params))
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse(icsTimeFormat, value)
+	}
+	return time.ParseInLocation("20060102T150405", value, icsLocation(params))
+}
+
+// icsLocation resolves a TZID parameter, treating floating times as UTC
+func icsLocation(params map[string]string) *time.Location {
+	if tzid := params["TZID"]; tzid != "" {
+		if loc, err := time.LoadLocation(tzid); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
@@ -10,11 +10,121 @@ type Schedules struct {
 }
 
 type Schedule struct {
-	User auth.User
-	Time TimeRange
+	User         auth.User
+	Time         TimeRange
+	ScheduleName string
+	Layer        Layer
 }
 
+// Layer lets a named schedule carry several independent lines of on-call.
+// Entries without a layer belong to the primary one.
+type Layer string
+
+const (
+	Primary   Layer = "primary"
+	Secondary Layer = "secondary"
+	Shadow    Layer = "shadow"
+)
+
+var Layers = []Layer{Primary, Secondary, Shadow}
+
 type TimeRange struct {
 	Start time.Time
 	End   time.Time
 }
+
+// Rotation hands the pager through Participants in order, one shift at a
+// time, starting at HandoffTime on StartDate in the rotation's time zone.
+type Rotation struct {
+	Id           string      `json:"id"`
+	TeamId       string      `json:"teamId"`
+	Name         string      `json:"name"`
+	ScheduleName string      `json:"scheduleName"`
+	Layer        Layer       `json:"layer"`
+	Participants []string    `json:"participants"`
+	ShiftLength  ShiftLength `json:"shiftLength"`
+	ShiftHours   int         `json:"shiftHours"`
+	HandoffTime  string      `json:"handoffTime"`
+	StartDate    time.Time   `json:"startDate"`
+	TimeZone     string      `json:"timeZone"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+}
+
+type ShiftLength string
+
+const (
+	Daily  ShiftLength = "daily"
+	Weekly ShiftLength = "weekly"
+	Custom ShiftLength = "custom"
+)
+
+type Shift struct {
+	UserCode string    `json:"userCode"`
+	Time     TimeRange `json:"time"`
+}
+
+// Override puts User on call for Time in place of whoever the base
+// schedule or rotation resolves to. Replaces optionally records the code
+// of the user being covered.
+type Override struct {
+	Id           string    `json:"id"`
+	TeamId       string    `json:"teamId"`
+	User         auth.User `json:"user"`
+	ScheduleName string    `json:"scheduleName"`
+	Layer        Layer     `json:"layer"`
+	Replaces     string    `json:"replaces"`
+	Time         TimeRange `json:"time"`
+	Reason       string    `json:"reason"`
+	CreatedBy    string    `json:"createdBy"`
+	CreatedAt    time.Time `json:"created_at"`
+	Cancelled    bool      `json:"cancelled"`
+	CancelledAt  time.Time `json:"cancelled_at"`
+}
+
+type SwapRequest struct {
+	Id           string     `json:"id"`
+	TeamId       string     `json:"teamId"`
+	Requester    auth.User  `json:"requester"`
+	Recipient    auth.User  `json:"recipient"`
+	ScheduleName string     `json:"scheduleName"`
+	Layer        Layer      `json:"layer"`
+	Time         TimeRange  `json:"time"`
+	Reason       string     `json:"reason"`
+	Status       SwapStatus `json:"status"`
+	Override     string     `json:"override"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RespondedAt  time.Time  `json:"responded_at"`
+}
+
+type SwapStatus string
+
+const (
+	SwapPending  SwapStatus = "Pending"
+	SwapAccepted SwapStatus = "Accepted"
+	SwapDeclined SwapStatus = "Declined"
+)
+
+// FeedToken grants read access to an ICS feed without a JWT. Feeds with a
+// UserCode only list that user's shifts, the others list the whole team.
+type FeedToken struct {
+	Token     string    `json:"token"`
+	TeamId    string    `json:"teamId"`
+	UserCode  string    `json:"userCode"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"created_at"`
+	Revoked   bool      `json:"revoked"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// TimeOff marks a user as unavailable for on-call. Backup optionally names
+// the user code that covers their shifts in the meantime.
+type TimeOff struct {
+	Id        string    `json:"id"`
+	TeamId    string    `json:"teamId"`
+	User      auth.User `json:"user"`
+	Time      TimeRange `json:"time"`
+	Reason    string    `json:"reason"`
+	Backup    string    `json:"backup"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,407 @@
+package schedules
+
+import (
+	"context"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/notification"
+	"issue-reporting/utils"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// overrideScheduled returns the override covering timestamp, the most
+// recently created one winning when several overlap.
+func overrideScheduled(timestamp time.Time, teamId string, name string, layer Layer) (*Schedule, error) {
+	ctx := context.Background()
+	filter := layerFilter(bson.M{
+		"teamid":     teamId,
+		"cancelled":  false,
+		"time.start": bson.M{"$lte": timestamp.UTC()},
+		"time.end":   bson.M{"$gt": timestamp.UTC()},
+	}, name, layer)
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdat", Value: -1}})
+
+	var override Override
+	err := database.GetDatabase().Database("IssueReporting").Collection("overrides").FindOne(ctx, filter, opts).Decode(&override)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &Schedule{User: override.User, Time: override.Time, ScheduleName: override.ScheduleName, Layer: layer}, nil
+}
+
+func insertOverride(override Override) (*Override, error) {
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		return nil, err
+	}
+	override.Id = code
+	override.CreatedAt = time.Now()
+
+	_, err = database.InsertOne("overrides", override)
+	if err != nil {
+		return nil, err
+	}
+	return &override, nil
+}
+
+func CreateOverride(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var body struct {
+		UserCode     string
+		Replaces     string
+		TimeRange    TimeRange
+		Reason       string
+		ScheduleName string
+		Layer        Layer
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return err
+	}
+
+	layer, err := VerifyLayer(body.Layer)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	if err := VerifyTimeRange(body.TimeRange); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	if body.TimeRange.End.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "override ends in the past",
+		})
+	}
+
+	var onCall auth.User
+	err = database.FindOne("users", bson.M{"code": body.UserCode, "teamId": user.TeamId}).Decode(&onCall)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Bad Request",
+				"message": "no user found",
+			})
+		}
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	override, err := insertOverride(Override{
+		TeamId:       user.TeamId,
+		User:         onCall,
+		ScheduleName: body.ScheduleName,
+		Layer:        layer,
+		Replaces:     body.Replaces,
+		Time:         body.TimeRange,
+		Reason:       body.Reason,
+		CreatedBy:    user.Code,
+	})
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "override not created")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":  "override created",
+		"override": override.Id,
+	})
+}
+
+func GetOverrides(c *fiber.Ctx) error {
+	ctx := context.Background()
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "time.start", Value: -1}})
+	cursor, err := database.GetDatabase().Database("IssueReporting").Collection("overrides").Find(ctx, bson.M{"teamid": user.TeamId}, opts)
+	if err != nil {
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting overrides")
+	}
+	defer cursor.Close(ctx)
+
+	var overrides []Override
+	if err := cursor.All(ctx, &overrides); err != nil {
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting overrides")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":   "all overrides",
+		"overrides": overrides,
+	})
+}
+
+// CancelOverride keeps the override for history but stops it applying
+func CancelOverride(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId, "cancelled": false}
+	update := bson.M{"$set": bson.M{"cancelled": true, "cancelledat": time.Now()}}
+
+	var override Override
+	err = database.FindOneAndUpdate("overrides", filter, update).Decode(&override)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusExpectationFailed, "No override found")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "override cancelled",
+		"override": &override,
+	})
+}
+
+func RequestSwap(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var body struct {
+		UserCode     string
+		TimeRange    TimeRange
+		Reason       string
+		ScheduleName string
+		Layer        Layer
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return err
+	}
+
+	layer, err := VerifyLayer(body.Layer)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	if err := VerifyTimeRange(body.TimeRange); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	if body.TimeRange.End.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "swap ends in the past",
+		})
+	}
+
+	if body.UserCode == user.Code {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "cannot swap a shift with yourself",
+		})
+	}
+
+	var recipient auth.User
+	err = database.FindOne("users", bson.M{"code": body.UserCode, "teamId": user.TeamId}).Decode(&recipient)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Bad Request",
+				"message": "no user found",
+			})
+		}
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	swap := SwapRequest{
+		Id:           code,
+		TeamId:       user.TeamId,
+		Requester:    user,
+		Recipient:    recipient,
+		ScheduleName: body.ScheduleName,
+		Layer:        layer,
+		Time:         body.TimeRange,
+		Reason:       body.Reason,
+		Status:       SwapPending,
+		CreatedAt:    time.Now(),
+	}
+
+	_, err = database.InsertOne("swaps", swap)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "swap request not created")
+	}
+
+	notification.SendNotification(fmt.Sprintf("%s asked you to cover their on-call shift\nFrom: %s\nTo: %s\nReason: %s", user.Name, swap.Time.Start.Format(time.RFC1123), swap.Time.End.Format(time.RFC1123), swap.Reason), recipient)
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "swap requested",
+		"swap":    swap.Id,
+	})
+}
+
+func GetSwaps(c *fiber.Ctx) error {
+	ctx := context.Background()
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter := bson.M{"teamid": user.TeamId}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdat", Value: -1}})
+	cursor, err := database.GetDatabase().Database("IssueReporting").Collection("swaps").Find(ctx, filter, opts)
+	if err != nil {
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting swaps")
+	}
+	defer cursor.Close(ctx)
+
+	var swaps []SwapRequest
+	if err := cursor.All(ctx, &swaps); err != nil {
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting swaps")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "all swaps",
+		"swaps":   swaps,
+	})
+}
+
+// AcceptSwap lets the recipient take over the shift, recorded as an override
+func AcceptSwap(c *fiber.Ctx) error {
+	return respondToSwap(c, SwapAccepted)
+}
+
+func DeclineSwap(c *fiber.Ctx) error {
+	return respondToSwap(c, SwapDeclined)
+}
+
+func respondToSwap(c *fiber.Ctx, status SwapStatus) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var swap SwapRequest
+	err = database.FindOne("swaps", bson.M{"id": c.Params("id"), "teamid": user.TeamId}).Decode(&swap)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusNotFound, "No swap request found")
+	}
+
+	if swap.Recipient.Code != user.Code {
+		return fiber.NewError(fiber.StatusForbidden, "Only the recipient can respond to a swap request")
+	}
+
+	if swap.Status != SwapPending {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "swap request already " + string(swap.Status),
+		})
+	}
+
+	// claim the request before creating anything so two responses racing
+	// each other cannot both produce an override
+	filter := bson.M{"id": swap.Id, "status": SwapPending}
+	set := bson.M{"status": status, "respondedat": time.Now()}
+	err = database.FindOneAndUpdate("swaps", filter, bson.M{"$set": set}).Decode(&swap)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "swap request already answered",
+		})
+	}
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	if status == SwapAccepted {
+		override, err := insertOverride(Override{
+			TeamId:       swap.TeamId,
+			User:         swap.Recipient,
+			ScheduleName: swap.ScheduleName,
+			Layer:        swap.Layer,
+			Replaces:     swap.Requester.Code,
+			Time:         swap.Time,
+			Reason:       fmt.Sprintf("shift swap %s", swap.Id),
+			CreatedBy:    user.Code,
+		})
+		if err != nil {
+			log.Println(err)
+			// hand the request back so it can be accepted again
+			reopen := bson.M{"$set": bson.M{"status": SwapPending}, "$unset": bson.M{"respondedat": ""}}
+			if _, err := database.UpdateOne("swaps", bson.M{"id": swap.Id, "status": SwapAccepted}, reopen); err != nil {
+				log.Println(err)
+			}
+			return fiber.NewError(fiber.StatusExpectationFailed, "override not created")
+		}
+		swap.Override = override.Id
+		_, err = database.UpdateOne("swaps", bson.M{"id": swap.Id}, bson.M{"$set": bson.M{"override": override.Id}})
+		if err != nil {
+			log.Println(err)
+			return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+		}
+	}
+
+	message := fmt.Sprintf("Shift swap %s: %s will cover %s's on-call shift\nFrom: %s\nTo: %s", swap.Status, swap.Recipient.Name, swap.Requester.Name, swap.Time.Start.Format(time.RFC1123), swap.Time.End.Format(time.RFC1123))
+	if status == SwapDeclined {
+		message = fmt.Sprintf("Shift swap %s: %s will not cover %s's on-call shift\nFrom: %s\nTo: %s", swap.Status, swap.Recipient.Name, swap.Requester.Name, swap.Time.Start.Format(time.RFC1123), swap.Time.End.Format(time.RFC1123))
+	}
+	notification.SendNotification(message, swap.Requester)
+	notification.SendNotification(message, swap.Recipient)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "swap " + string(swap.Status),
+		"swap":    &swap,
+	})
+}
@@ -0,0 +1,480 @@
+package schedules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/utils"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxPreviewShifts caps how many shifts a single preview can compute
+const maxPreviewShifts = 2000
+
+func VerifyRotation(rotation Rotation) error {
+	if rotation.Name == "" {
+		return errors.New("rotation name is required")
+	}
+
+	if len(rotation.Participants) == 0 {
+		return errors.New("rotation needs at least one participant")
+	}
+
+	switch rotation.ShiftLength {
+	case Daily, Weekly:
+	case Custom:
+		if rotation.ShiftHours <= 0 {
+			return errors.New("custom shifts need shiftHours greater than zero")
+		}
+	default:
+		return fmt.Errorf("unknown shift length %q", rotation.ShiftLength)
+	}
+
+	if _, err := VerifyLayer(rotation.Layer); err != nil {
+		return err
+	}
+
+	if rotation.StartDate.IsZero() {
+		return errors.New("start date is required")
+	}
+
+	if _, err := rotation.anchor(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// anchor is the moment the first shift of the rotation begins
+func (r Rotation) anchor() (time.Time, error) {
+	zone := r.TimeZone
+	if zone == "" {
+		zone = "UTC"
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time zone %q", r.TimeZone)
+	}
+
+	handoff := r.HandoffTime
+	if handoff == "" {
+		handoff = "09:00"
+	}
+	clock, err := time.Parse("15:04", handoff)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid handoff time %q, expected HH:MM", r.HandoffTime)
+	}
+
+	start := r.StartDate.In(loc)
+	return time.Date(start.Year(), start.Month(), start.Day(), clock.Hour(), clock.Minute(), 0, 0, loc), nil
+}
+
+// shiftStart returns the start of the nth shift. Daily and weekly shifts
+// follow the calendar so handoffs stay at the same wall-clock time across
+// daylight saving changes.
+func (r Rotation) shiftStart(anchor time.Time, n int) time.Time {
+	switch r.ShiftLength {
+	case Daily:
+		return anchor.AddDate(0, 0, n)
+	case Weekly:
+		return anchor.AddDate(0, 0, 7*n)
+	default:
+		return anchor.Add(time.Duration(n*r.ShiftHours) * time.Hour)
+	}
+}
+
+func (r Rotation) nominalLength() time.Duration {
+	switch r.ShiftLength {
+	case Daily:
+		return 24 * time.Hour
+	case Weekly:
+		return 7 * 24 * time.Hour
+	default:
+		return time.Duration(r.ShiftHours) * time.Hour
+	}
+}
+
+// shiftIndex returns the number of the shift running at t
+func (r Rotation) shiftIndex(anchor, t time.Time) int {
+	n := int(t.Sub(anchor) / r.nominalLength())
+	for n > 0 && r.shiftStart(anchor, n).After(t) {
+		n--
+	}
+	for !r.shiftStart(anchor, n+1).After(t) {
+		n++
+	}
+	return n
+}
+
+func (r Rotation) shift(anchor time.Time, n int) Shift {
+	return Shift{
+		UserCode: r.Participants[n%len(r.Participants)],
+		Time: TimeRange{
+			Start: r.shiftStart(anchor, n).UTC(),
+			End:   r.shiftStart(anchor, n+1).UTC(),
+		},
+	}
+}
+
+// ShiftAt returns the shift covering t, if the rotation has started by then
+func (r Rotation) ShiftAt(t time.Time) (*Shift, error) {
+	if err := VerifyRotation(r); err != nil {
+		return nil, err
+	}
+	anchor, _ := r.anchor()
+	if t.Before(anchor) {
+		return nil, nil
+	}
+
+	shift := r.shift(anchor, r.shiftIndex(anchor, t))
+	return &shift, nil
+}
+
+// ShiftsBetween lists every shift overlapping the window start to end
+func (r Rotation) ShiftsBetween(start, end time.Time) ([]Shift, error) {
+	if err := VerifyRotation(r); err != nil {
+		return nil, err
+	}
+	anchor, _ := r.anchor()
+
+	n := 0
+	if start.After(anchor) {
+		n = r.shiftIndex(anchor, start)
+	}
+
+	var shifts []Shift
+	for r.shiftStart(anchor, n).Before(end) {
+		if len(shifts) >= maxPreviewShifts {
+			return nil, fmt.Errorf("window covers more than %d shifts", maxPreviewShifts)
+		}
+		shifts = append(shifts, r.shift(anchor, n))
+		n++
+	}
+	return shifts, nil
+}
+
+func teamRotations(filter bson.M) ([]Rotation, error) {
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "createdat", Value: 1}})
+	cursor, err := database.GetDatabase().Database("IssueReporting").Collection("rotations").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rotations []Rotation
+	if err := cursor.All(ctx, &rotations); err != nil {
+		return nil, err
+	}
+	return rotations, nil
+}
+
+// rotationScheduled resolves who a team's rotations put on call at timestamp
+// for one schedule layer. Rotations are checked oldest first and the first
+// one with a shift wins.
+func rotationScheduled(timestamp time.Time, teamId string, name string, layer Layer) (*Schedule, error) {
+	rotations, err := teamRotations(layerFilter(bson.M{"teamid": teamId}, name, layer))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rotation := range rotations {
+		shift, err := rotation.ShiftAt(timestamp)
+		if err != nil {
+			log.Println("skipping invalid rotation", rotation.Id, err)
+			continue
+		}
+		if shift == nil {
+			continue
+		}
+
+		// participants on time off are covered by their backup, or skipped
+		// in favour of whoever is next in the rotation
+		anchor, _ := rotation.anchor()
+		n := rotation.shiftIndex(anchor, timestamp)
+		for k := range rotation.Participants {
+			code := rotation.Participants[(n+k)%len(rotation.Participants)]
+
+			var user auth.User
+			err = database.FindOne("users", bson.M{"code": code, "teamId": teamId}).Decode(&user)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					log.Println("rotation participant not found", rotation.Id, code)
+					continue
+				}
+				return nil, err
+			}
+
+			schedule, err := available(&Schedule{User: user, Time: shift.Time, ScheduleName: rotation.ScheduleName, Layer: layer}, teamId, timestamp)
+			if err != nil || schedule != nil {
+				return schedule, err
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func verifyParticipants(teamId string, participants []string) error {
+	for _, code := range participants {
+		var user auth.User
+		err := database.FindOne("users", bson.M{"code": code, "teamId": teamId}).Decode(&user)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return fmt.Errorf("no user found for participant %s", code)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func CreateRotation(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var rotation Rotation
+	if err := c.BodyParser(&rotation); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if err := VerifyRotation(rotation); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	if err := verifyParticipants(user.TeamId, rotation.Participants); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	rotation.Id = code
+	rotation.TeamId = user.TeamId
+	rotation.Layer, _ = VerifyLayer(rotation.Layer)
+	rotation.CreatedAt = time.Now()
+	rotation.UpdatedAt = time.Now()
+
+	_, err = database.InsertOne("rotations", rotation)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "rotation not created")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":  "rotation created",
+		"rotation": rotation.Id,
+	})
+}
+
+func GetRotations(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	rotations, err := teamRotations(bson.M{"teamid": user.TeamId})
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting rotations")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":   "all rotations",
+		"rotations": rotations,
+	})
+}
+
+func GetRotation(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var rotation Rotation
+	err = database.FindOne("rotations", bson.M{"id": c.Params("id"), "teamid": user.TeamId}).Decode(&rotation)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusNotFound, "No rotation found")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":  "rotation data",
+		"rotation": &rotation,
+	})
+}
+
+func UpdateRotation(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var body Rotation
+	if err := c.BodyParser(&body); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	if err := VerifyRotation(body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	if err := verifyParticipants(user.TeamId, body.Participants); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	layer, _ := VerifyLayer(body.Layer)
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+	update := bson.M{"$set": bson.M{
+		"name":         body.Name,
+		"schedulename": body.ScheduleName,
+		"layer":        layer,
+		"participants": body.Participants,
+		"shiftlength":  body.ShiftLength,
+		"shifthours":   body.ShiftHours,
+		"handofftime":  body.HandoffTime,
+		"startdate":    body.StartDate,
+		"timezone":     body.TimeZone,
+		"updatedat":    time.Now(),
+	}}
+
+	var rotation Rotation
+	err = database.FindOneAndUpdate("rotations", filter, update).Decode(&rotation)
+	if err != nil {
+		return fiber.NewError(fiber.StatusNoContent, err.Error())
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "rotation updated",
+		"rotation": &rotation,
+	})
+}
+
+func DeleteRotation(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+
+	var rotation Rotation
+	err = database.FindOne("rotations", filter).Decode(&rotation)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusExpectationFailed, "No rotation found")
+	}
+
+	_, err = database.InsertOne("deletedrotations", rotation)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	_, err = database.DeleteOne("rotations", filter)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "rotation deleted",
+		"rotation": &rotation,
+	})
+}
+
+// PreviewRotation computes the shifts of a rotation between the start and
+// end query parameters (RFC3339), defaulting to the next two weeks.
+func PreviewRotation(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var rotation Rotation
+	err = database.FindOne("rotations", bson.M{"id": c.Params("id"), "teamid": user.TeamId}).Decode(&rotation)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusNotFound, "No rotation found")
+	}
+
+	window := TimeRange{Start: time.Now(), End: time.Now().AddDate(0, 0, 14)}
+	if start := c.Query("start"); start != "" {
+		window.Start, err = time.Parse(time.RFC3339, start)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid start timestamp")
+		}
+	}
+	if end := c.Query("end"); end != "" {
+		window.End, err = time.Parse(time.RFC3339, end)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid end timestamp")
+		}
+	}
+	if err := VerifyTimeRange(window); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	shifts, err := rotation.ShiftsBetween(window.Start, window.End)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "rotation shifts",
+		"shifts":  shifts,
+	})
+}
@@ -0,0 +1,206 @@
+package schedules
+
+import (
+	"testing"
+	"time"
+)
+
+func testRotation(length ShiftLength, zone string, start time.Time) Rotation {
+	return Rotation{
+		Name:         "Primary rotation",
+		Layer:        Primary,
+		Participants: []string{"ann", "bob", "cid"},
+		ShiftLength:  length,
+		HandoffTime:  "09:00",
+		StartDate:    start,
+		TimeZone:     zone,
+	}
+}
+
+func utc(year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestRotationAnchor(t *testing.T) {
+	tests := []struct {
+		name     string
+		zone     string
+		handoff  string
+		want     time.Time
+		hasError bool
+	}{
+		{"defaults to 09:00 UTC", "", "", utc(2024, 3, 1, 9, 0), false},
+		{"handoff in the rotation's zone", "America/New_York", "17:30", utc(2024, 2, 29, 22, 30), false},
+		{"start date taken in the rotation's zone", "Asia/Tokyo", "08:00", utc(2024, 2, 29, 23, 0), false},
+		{"unknown zone", "Mars/Olympus", "09:00", time.Time{}, true},
+		{"handoff not HH:MM", "UTC", "9am", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		rotation := testRotation(Daily, tt.zone, utc(2024, 3, 1, 0, 0))
+		rotation.HandoffTime = tt.handoff
+		got, err := rotation.anchor()
+		if (err != nil) != tt.hasError {
+			t.Errorf("%s: error %v", tt.name, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: anchor %s, want %s", tt.name, got.UTC(), tt.want)
+		}
+	}
+}
+
+func TestShiftAt(t *testing.T) {
+	daily := testRotation(Daily, "UTC", utc(2024, 3, 1, 0, 0))
+	weekly := testRotation(Weekly, "UTC", utc(2024, 3, 4, 0, 0))
+	custom := testRotation(Custom, "UTC", utc(2024, 3, 1, 0, 0))
+	custom.ShiftHours = 12
+
+	tests := []struct {
+		name     string
+		rotation Rotation
+		at       time.Time
+		user     string
+		start    time.Time
+		end      time.Time
+	}{
+		{"first shift", daily, utc(2024, 3, 1, 12, 0), "ann", utc(2024, 3, 1, 9, 0), utc(2024, 3, 2, 9, 0)},
+		{"at the handoff", daily, utc(2024, 3, 2, 9, 0), "bob", utc(2024, 3, 2, 9, 0), utc(2024, 3, 3, 9, 0)},
+		{"just before the handoff", daily, utc(2024, 3, 2, 8, 59), "ann", utc(2024, 3, 1, 9, 0), utc(2024, 3, 2, 9, 0)},
+		{"wraps around the participants", daily, utc(2024, 3, 4, 10, 0), "ann", utc(2024, 3, 4, 9, 0), utc(2024, 3, 5, 9, 0)},
+		{"far ahead", daily, utc(2025, 3, 1, 10, 0), "cid", utc(2025, 3, 1, 9, 0), utc(2025, 3, 2, 9, 0)},
+		{"weekly", weekly, utc(2024, 3, 20, 0, 0), "cid", utc(2024, 3, 18, 9, 0), utc(2024, 3, 25, 9, 0)},
+		{"custom length", custom, utc(2024, 3, 1, 22, 0), "bob", utc(2024, 3, 1, 21, 0), utc(2024, 3, 2, 9, 0)},
+	}
+	for _, tt := range tests {
+		shift, err := tt.rotation.ShiftAt(tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shift == nil {
+			t.Errorf("%s: no shift", tt.name)
+			continue
+		}
+		if shift.UserCode != tt.user || !shift.Time.Start.Equal(tt.start) || !shift.Time.End.Equal(tt.end) {
+			t.Errorf("%s: %s from %s to %s, want %s from %s to %s", tt.name, shift.UserCode, shift.Time.Start, shift.Time.End, tt.user, tt.start, tt.end)
+		}
+	}
+}
+
+func TestShiftAtBeforeStart(t *testing.T) {
+	rotation := testRotation(Daily, "UTC", utc(2024, 3, 1, 0, 0))
+	for _, at := range []time.Time{utc(2024, 2, 1, 12, 0), utc(2024, 3, 1, 8, 59)} {
+		shift, err := rotation.ShiftAt(at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shift != nil {
+			t.Errorf("ShiftAt(%s) = %+v before the rotation started", at, shift)
+		}
+	}
+}
+
+// handoffs keep their wall-clock time across daylight saving changes, so
+// the shift spanning the change is an hour shorter or longer
+func TestShiftAtDaylightSaving(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	tests := []struct {
+		name     string
+		rotation Rotation
+		at       time.Time
+		start    time.Time
+		length   time.Duration
+	}{
+		// clocks go forward on 10 March 2024 in New York
+		{"daily into summer time", testRotation(Daily, "America/New_York", utc(2024, 3, 9, 12, 0)), utc(2024, 3, 9, 20, 0), time.Date(2024, 3, 9, 9, 0, 0, 0, newYork), 23 * time.Hour},
+		{"daily after the change", testRotation(Daily, "America/New_York", utc(2024, 3, 9, 12, 0)), utc(2024, 3, 10, 13, 30), time.Date(2024, 3, 10, 9, 0, 0, 0, newYork), 24 * time.Hour},
+		// and back on 27 October 2024 in Berlin
+		{"weekly out of summer time", testRotation(Weekly, "Europe/Berlin", utc(2024, 10, 21, 12, 0)), utc(2024, 10, 27, 12, 0), time.Date(2024, 10, 21, 9, 0, 0, 0, berlin), 169 * time.Hour},
+	}
+	for _, tt := range tests {
+		shift, err := tt.rotation.ShiftAt(tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shift == nil {
+			t.Errorf("%s: no shift", tt.name)
+			continue
+		}
+		if !shift.Time.Start.Equal(tt.start) || shift.Time.End.Sub(shift.Time.Start) != tt.length {
+			t.Errorf("%s: shift from %s to %s, want %s lasting %s", tt.name, shift.Time.Start, shift.Time.End, tt.start.UTC(), tt.length)
+		}
+	}
+
+	// custom shifts are fixed durations and drift off the wall clock
+	custom := testRotation(Custom, "America/New_York", utc(2024, 3, 9, 12, 0))
+	custom.ShiftHours = 12
+	shift, err := custom.ShiftAt(utc(2024, 3, 10, 15, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 3, 10, 10, 0, 0, 0, newYork); !shift.Time.Start.Equal(want) {
+		t.Errorf("custom shift starts %s, want %s", shift.Time.Start, want.UTC())
+	}
+}
+
+func TestShiftsBetween(t *testing.T) {
+	rotation := testRotation(Daily, "UTC", utc(2024, 3, 1, 0, 0))
+
+	tests := []struct {
+		name   string
+		start  time.Time
+		end    time.Time
+		starts []time.Time
+	}{
+		{"window before the start", utc(2024, 2, 1, 0, 0), utc(2024, 3, 2, 12, 0), []time.Time{utc(2024, 3, 1, 9, 0), utc(2024, 3, 2, 9, 0)}},
+		{"window within a shift", utc(2024, 3, 5, 10, 0), utc(2024, 3, 5, 11, 0), []time.Time{utc(2024, 3, 5, 9, 0)}},
+		{"window ending at a handoff", utc(2024, 3, 5, 10, 0), utc(2024, 3, 6, 9, 0), []time.Time{utc(2024, 3, 5, 9, 0)}},
+		{"window ending before the start", utc(2024, 2, 1, 0, 0), utc(2024, 3, 1, 9, 0), nil},
+	}
+	for _, tt := range tests {
+		shifts, err := rotation.ShiftsBetween(tt.start, tt.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(shifts) != len(tt.starts) {
+			t.Errorf("%s: %d shifts, want %d", tt.name, len(shifts), len(tt.starts))
+			continue
+		}
+		for i, shift := range shifts {
+			if !shift.Time.Start.Equal(tt.starts[i]) {
+				t.Errorf("%s: shift %d starts %s, want %s", tt.name, i, shift.Time.Start, tt.starts[i])
+			}
+		}
+	}
+
+	// the same shifts ShiftAt finds
+	shifts, err := rotation.ShiftsBetween(utc(2024, 3, 1, 0, 0), utc(2024, 3, 8, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, shift := range shifts {
+		at, err := rotation.ShiftAt(shift.Time.Start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if at.UserCode != shift.UserCode || !at.Time.End.Equal(shift.Time.End) {
+			t.Errorf("ShiftsBetween has %+v, ShiftAt has %+v", shift, at)
+		}
+	}
+
+	if _, err := rotation.ShiftsBetween(utc(2024, 3, 1, 0, 0), utc(2030, 1, 1, 0, 0)); err == nil {
+		t.Error("a window of more than maxPreviewShifts shifts was accepted")
+	}
+}
+
+func TestShiftAtInvalidRotation(t *testing.T) {
+	rotation := testRotation(Custom, "UTC", utc(2024, 3, 1, 0, 0))
+	if _, err := rotation.ShiftAt(utc(2024, 3, 2, 0, 0)); err == nil {
+		t.Error("custom rotation without shiftHours was accepted")
+	}
+	rotation = testRotation(Daily, "UTC", time.Time{})
+	if _, err := rotation.ShiftsBetween(utc(2024, 3, 1, 0, 0), utc(2024, 3, 2, 0, 0)); err == nil {
+		t.Error("rotation without a start date was accepted")
+	}
+}
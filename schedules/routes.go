@@ -7,11 +7,36 @@ import (
 )
 
 func RegisterRoutes(app *fiber.App) {
+	// calendar apps cannot send a JWT, the feed token authenticates instead
+	app.Get("/ical/:token", ServeFeed)
+
 	schedule := app.Group("/schedules").Use(middleware.AuthMiddleware())
 	schedule.Get("/", GetAllSchedules)
 	schedule.Get("/now", GetScheduledNow)
 	schedule.Get("/time/:timestamp", GetScheduledAt)
 	schedule.Post("/range", ListByTimeRange)
+	schedule.Post("/rotations", CreateRotation)
+	schedule.Get("/rotations", GetRotations)
+	schedule.Get("/rotations/:id", GetRotation)
+	schedule.Get("/rotations/:id/shifts", PreviewRotation)
+	schedule.Put("/rotations/:id", UpdateRotation)
+	schedule.Delete("/rotations/:id", DeleteRotation)
+	schedule.Post("/overrides", CreateOverride)
+	schedule.Get("/overrides", GetOverrides)
+	schedule.Delete("/overrides/:id", CancelOverride)
+	schedule.Post("/swaps", RequestSwap)
+	schedule.Get("/swaps", GetSwaps)
+	schedule.Put("/swaps/:id/accept", AcceptSwap)
+	schedule.Put("/swaps/:id/decline", DeclineSwap)
+	schedule.Post("/feeds", CreateFeed)
+	schedule.Get("/feeds", GetFeeds)
+	schedule.Delete("/feeds/:token", RevokeFeed)
+	schedule.Post("/import", ImportSchedules)
+	schedule.Get("/gaps", GetCoverageGaps)
+	schedule.Post("/timeoff", CreateTimeOff)
+	schedule.Get("/timeoff", GetTimeOff)
+	schedule.Delete("/timeoff/:id", DeleteTimeOff)
+	schedule.Get("/calendar", GetCalendar)
 	schedule.Delete("/:id", DeleteSchedule)
 	schedule.Put("/:id", UpdateSchedules)
 	schedule.Post("/:userCode", CreateSchedules)
@@ -0,0 +1,443 @@
+package schedules
+
+import (
+	"context"
+	"fmt"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/utils"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxCalendarDays caps the window a single calendar request can cover
+const maxCalendarDays = 92
+
+// timeOffAt returns the time off of a user covering timestamp, if any
+func timeOffAt(teamId, userCode string, timestamp time.Time) (*TimeOff, error) {
+	filter := bson.M{
+		"teamid":     teamId,
+		"user.code":  userCode,
+		"time.start": bson.M{"$lte": timestamp.UTC()},
+		"time.end":   bson.M{"$gt": timestamp.UTC()},
+	}
+
+	var timeOff TimeOff
+	err := database.FindOne("timeoff", filter).Decode(&timeOff)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &timeOff, nil
+}
+
+// TimeOffDuring returns a time off of the user overlapping timeRange, if any
+func TimeOffDuring(teamId, userCode string, timeRange TimeRange) (*TimeOff, error) {
+	filter := bson.M{
+		"teamid":     teamId,
+		"user.code":  userCode,
+		"time.start": bson.M{"$lt": timeRange.End.UTC()},
+		"time.end":   bson.M{"$gt": timeRange.Start.UTC()},
+	}
+
+	var timeOff TimeOff
+	err := database.FindOne("timeoff", filter).Decode(&timeOff)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &timeOff, nil
+}
+
+// available returns schedule when its user can be on call at timestamp. A
+// user on time off is replaced by their backup when the backup is free,
+// otherwise nil is returned so the caller can look elsewhere.
+func available(schedule *Schedule, teamId string, timestamp time.Time) (*Schedule, error) {
+	timeOff, err := timeOffAt(teamId, schedule.User.Code, timestamp)
+	if err != nil || timeOff == nil {
+		return schedule, err
+	}
+	if timeOff.Backup == "" {
+		return nil, nil
+	}
+
+	backupOff, err := timeOffAt(teamId, timeOff.Backup, timestamp)
+	if err != nil || backupOff != nil {
+		return nil, err
+	}
+
+	var backup auth.User
+	err = database.FindOne("users", bson.M{"code": timeOff.Backup, "teamId": teamId}).Decode(&backup)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			log.Println("time off backup not found", timeOff.Id, timeOff.Backup)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	covered := *schedule
+	covered.User = backup
+	return &covered, nil
+}
+
+func teamTimeOff(filter bson.M) ([]TimeOff, error) {
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "time.start", Value: 1}})
+	cursor, err := database.GetDatabase().Database("IssueReporting").Collection("timeoff").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var list []TimeOff
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Timeline resolves who is effectively on call for one layer of a named
+// schedule over window, after overrides, rotations and time off. It splits
+// window wherever any source changes and resolves each piece, merging
+// neighbouring pieces held by the same user.
+func Timeline(teamId string, name string, layer Layer, window TimeRange) ([]Schedule, error) {
+	points, err := boundaries(teamId, name, layer, window)
+	if err != nil {
+		return nil, err
+	}
+
+	var timeline []Schedule
+	for i := 0; i+1 < len(points); i++ {
+		segment := TimeRange{Start: points[i], End: points[i+1]}
+		// entries match their own end, so resolve inside the segment
+		middle := segment.Start.Add(segment.End.Sub(segment.Start) / 2)
+		schedule, err := ScheduledLayer(middle, teamId, name, layer)
+		if err != nil {
+			return nil, err
+		}
+		if schedule == nil {
+			continue
+		}
+
+		if last := len(timeline) - 1; last >= 0 &&
+			timeline[last].User.Email == schedule.User.Email &&
+			timeline[last].ScheduleName == schedule.ScheduleName &&
+			timeline[last].Time.End.Equal(segment.Start) {
+			timeline[last].Time.End = segment.End
+			continue
+		}
+		timeline = append(timeline, Schedule{User: schedule.User, Time: segment, ScheduleName: schedule.ScheduleName, Layer: layer})
+	}
+	return timeline, nil
+}
+
+// boundaries returns the sorted instants within window at which the on-call
+// user may change
+func boundaries(teamId string, name string, layer Layer, window TimeRange) ([]time.Time, error) {
+	ctx := context.Background()
+	points := []time.Time{window.Start.UTC(), window.End.UTC()}
+	add := func(r TimeRange) {
+		for _, t := range []time.Time{r.Start, r.End} {
+			if t.After(window.Start) && t.Before(window.End) {
+				points = append(points, t.UTC())
+			}
+		}
+	}
+	overlapping := func(filter bson.M) bson.M {
+		filter["time.start"] = bson.M{"$lt": window.End.UTC()}
+		filter["time.end"] = bson.M{"$gt": window.Start.UTC()}
+		return filter
+	}
+
+	cursor, err := database.Find("schedules", overlapping(layerFilter(bson.M{"user.teamId": teamId}, name, layer)))
+	if err != nil {
+		return nil, err
+	}
+	var entries []Schedule
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		add(entry.Time)
+	}
+
+	cursor, err = database.Find("overrides", overlapping(layerFilter(bson.M{"teamid": teamId, "cancelled": false}, name, layer)))
+	if err != nil {
+		return nil, err
+	}
+	var overrides []Override
+	if err := cursor.All(ctx, &overrides); err != nil {
+		return nil, err
+	}
+	for _, override := range overrides {
+		add(override.Time)
+	}
+
+	timeOff, err := teamTimeOff(overlapping(bson.M{"teamid": teamId}))
+	if err != nil {
+		return nil, err
+	}
+	for _, absence := range timeOff {
+		add(absence.Time)
+	}
+
+	rotations, err := teamRotations(layerFilter(bson.M{"teamid": teamId}, name, layer))
+	if err != nil {
+		return nil, err
+	}
+	for _, rotation := range rotations {
+		shifts, err := rotation.ShiftsBetween(window.Start, window.End)
+		if err != nil {
+			log.Println("skipping invalid rotation", rotation.Id, err)
+			continue
+		}
+		for _, shift := range shifts {
+			add(shift.Time)
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Before(points[j])
+	})
+	unique := points[:1]
+	for _, t := range points[1:] {
+		if !t.Equal(unique[len(unique)-1]) {
+			unique = append(unique, t)
+		}
+	}
+	if len(unique) > maxPreviewShifts {
+		return nil, fmt.Errorf("window covers more than %d shift changes", maxPreviewShifts)
+	}
+	return unique, nil
+}
+
+// CreateTimeOff registers an absence for the caller, or for the team member
+// in userCode. Backup optionally names who covers their shifts meanwhile.
+func CreateTimeOff(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	var body struct {
+		UserCode  string
+		TimeRange TimeRange
+		Reason    string
+		Backup    string
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return err
+	}
+
+	if err := VerifyTimeRange(body.TimeRange); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	if body.TimeRange.End.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "time off ends in the past",
+		})
+	}
+
+	absent := user
+	if body.UserCode != "" && body.UserCode != user.Code {
+		err = database.FindOne("users", bson.M{"code": body.UserCode, "teamId": user.TeamId}).Decode(&absent)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error":   "Bad Request",
+					"message": "no user found",
+				})
+			}
+			log.Println(err)
+			return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+		}
+	}
+
+	if body.Backup != "" {
+		if body.Backup == absent.Code {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Bad Request",
+				"message": "backup must be someone else",
+			})
+		}
+		if err := verifyParticipants(user.TeamId, []string{body.Backup}); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Bad Request",
+				"message": err.Error(),
+			})
+		}
+	}
+
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	timeOff := TimeOff{
+		Id:        code,
+		TeamId:    user.TeamId,
+		User:      absent,
+		Time:      body.TimeRange,
+		Reason:    body.Reason,
+		Backup:    body.Backup,
+		CreatedAt: time.Now(),
+	}
+
+	_, err = database.InsertOne("timeoff", timeOff)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "time off not created")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "time off created",
+		"timeOff": timeOff,
+	})
+}
+
+// GetTimeOff lists the team's current and upcoming absences, or only those
+// of the user in the userCode query
+func GetTimeOff(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter := bson.M{"teamid": user.TeamId, "time.end": bson.M{"$gt": time.Now().UTC()}}
+	if userCode := c.Query("userCode"); userCode != "" {
+		filter["user.code"] = userCode
+	}
+
+	timeOff, err := teamTimeOff(filter)
+	if err != nil {
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting time off")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message": "all time off",
+		"timeOff": timeOff,
+	})
+}
+
+func DeleteTimeOff(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	filter := bson.M{"id": c.Params("id"), "teamid": user.TeamId}
+	var timeOff TimeOff
+	err = database.FindOne("timeoff", filter).Decode(&timeOff)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+	if err == mongo.ErrNoDocuments {
+		return fiber.NewError(fiber.StatusExpectationFailed, "No time off found")
+	}
+
+	_, err = database.InsertOne("deletedtimeoff", timeOff)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	_, err = database.DeleteOne("timeoff", filter)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "time off deleted",
+		"timeOff": &timeOff,
+	})
+}
+
+// GetCalendar shows who is effectively on call and who is away between the
+// start and end queries (default the next 14 days), for the primary layer or
+// the layer and schedule given in the query.
+func GetCalendar(c *fiber.Ctx) error {
+	email := c.Locals("email").(string)
+	var user auth.User
+	err := database.FindOne("users", bson.M{"email": email}).Decode(&user)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	window := TimeRange{Start: time.Now(), End: time.Now().AddDate(0, 0, 14)}
+	if start := c.Query("start"); start != "" {
+		window.Start, err = time.Parse(time.RFC3339, start)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid start timestamp")
+		}
+	}
+	if end := c.Query("end"); end != "" {
+		window.End, err = time.Parse(time.RFC3339, end)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid end timestamp")
+		}
+	}
+	if err := VerifyTimeRange(window); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+	if window.End.Sub(window.Start) > maxCalendarDays*24*time.Hour {
+		return fiber.NewError(fiber.StatusBadRequest, "calendar window is limited to 92 days")
+	}
+
+	layer, err := VerifyLayer(Layer(c.Query("layer")))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	}
+
+	shifts, err := Timeline(user.TeamId, c.Query("schedule"), layer, window)
+	if err != nil {
+		log.Println(err)
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting the calendar")
+	}
+
+	absences, err := teamTimeOff(bson.M{
+		"teamid":     user.TeamId,
+		"time.start": bson.M{"$lt": window.End.UTC()},
+		"time.end":   bson.M{"$gt": window.Start.UTC()},
+	})
+	if err != nil {
+		return fiber.NewError(fiber.StatusExpectationFailed, "Something went wrong getting the calendar")
+	}
+
+	return c.Status(200).JSON(fiber.Map{
+		"message":  "team calendar",
+		"window":   window,
+		"shifts":   shifts,
+		"absences": absences,
+	})
+}
@@ -0,0 +1,162 @@
+package syslog
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a parsed syslog message
+type Message struct {
+	Facility       int       `json:"facility"`
+	Severity       int       `json:"severity"`
+	Timestamp      time.Time `json:"timestamp"`
+	Hostname       string    `json:"hostname"`
+	AppName        string    `json:"appName"`
+	ProcId         string    `json:"procId"`
+	MsgId          string    `json:"msgId"`
+	StructuredData string    `json:"structuredData"`
+	Text           string    `json:"text"`
+}
+
+// severities are the RFC 5424 severity keywords by code
+var severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// SeverityName returns the keyword of the message severity, e.g. err
+func (m Message) SeverityName() string {
+	if m.Severity < 0 || m.Severity >= len(severities) {
+		return ""
+	}
+	return severities[m.Severity]
+}
+
+// Parse reads an RFC 5424 message, falling back to the older BSD format of
+// RFC 3164. Whatever cannot be made sense of ends up in Text so nothing is
+// lost.
+func Parse(raw string, now time.Time) (Message, error) {
+	raw = strings.TrimRight(raw, "\r\n\x00")
+	message := Message{Facility: 1, Severity: 5, Timestamp: now}
+
+	if !strings.HasPrefix(raw, "<") {
+		message.Text = raw
+		return message, nil
+	}
+	end := strings.IndexByte(raw, '>')
+	if end < 2 || end > 4 {
+		return message, errors.New("invalid priority")
+	}
+	priority, err := strconv.Atoi(raw[1:end])
+	if err != nil || priority > 191 {
+		return message, errors.New("invalid priority")
+	}
+	message.Facility = priority / 8
+	message.Severity = priority % 8
+	rest := raw[end+1:]
+
+	if strings.HasPrefix(rest, "1 ") {
+		parse5424(&message, rest[2:])
+		return message, nil
+	}
+	parse3164(&message, rest, now)
+	return message, nil
+}
+
+// parse5424 reads TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+func parse5424(message *Message, rest string) {
+	fields := make([]string, 5)
+	for i := range fields {
+		rest = strings.TrimLeft(rest, " ")
+		field, remainder, _ := strings.Cut(rest, " ")
+		fields[i], rest = nilValue(field), remainder
+	}
+
+	if fields[0] != "" {
+		if timestamp, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+			message.Timestamp = timestamp
+		}
+	}
+	message.Hostname = fields[1]
+	message.AppName = fields[2]
+	message.ProcId = fields[3]
+	message.MsgId = fields[4]
+
+	rest = strings.TrimLeft(rest, " ")
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else if strings.HasPrefix(rest, "[") {
+		end := structuredDataEnd(rest)
+		message.StructuredData = rest[:end]
+		rest = rest[end:]
+	}
+
+	// a UTF-8 byte order mark may precede the text
+	message.Text = strings.TrimPrefix(strings.TrimLeft(rest, " "), "\ufeff")
+}
+
+// structuredDataEnd finds where the SD-ELEMENTs at the start of s end,
+// honouring escaped characters inside quoted values
+func structuredDataEnd(s string) int {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if inQuotes {
+				i++
+			}
+		case '"':
+			inQuotes = !inQuotes
+		case ']':
+			if !inQuotes && (i+1 == len(s) || s[i+1] != '[') {
+				return i + 1
+			}
+		}
+	}
+	return len(s)
+}
+
+func nilValue(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+// parse3164 reads Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG
+func parse3164(message *Message, rest string, now time.Time) {
+	if len(rest) >= 16 && rest[15] == ' ' {
+		if timestamp, err := time.ParseInLocation(time.Stamp, rest[:15], now.Location()); err == nil {
+			// the year is not sent, a date ahead of now is from last year
+			timestamp = timestamp.AddDate(now.Year(), 0, 0)
+			if timestamp.After(now.Add(24 * time.Hour)) {
+				timestamp = timestamp.AddDate(-1, 0, 0)
+			}
+			message.Timestamp = timestamp
+			rest = rest[16:]
+
+			if host, remainder, found := strings.Cut(rest, " "); found && !strings.HasSuffix(host, ":") {
+				message.Hostname = host
+				rest = remainder
+			}
+		}
+	}
+
+	// the tag is alphanumeric and ends at [, : or a space
+	tagEnd := strings.IndexAny(rest, "[: ")
+	if tagEnd > 0 && tagEnd <= 48 {
+		tag := rest[:tagEnd]
+		remainder := rest[tagEnd:]
+		if strings.HasPrefix(remainder, "[") {
+			if pidEnd := strings.IndexByte(remainder, ']'); pidEnd != -1 {
+				message.ProcId = remainder[1:pidEnd]
+				remainder = remainder[pidEnd+1:]
+			}
+		}
+		if strings.HasPrefix(remainder, ":") {
+			message.AppName = tag
+			rest = remainder[1:]
+		}
+	}
+
+	message.Text = strings.TrimLeft(rest, " ")
+}
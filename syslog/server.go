@@ -0,0 +1,240 @@
+package syslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"issue-reporting/api"
+	"issue-reporting/auth"
+	"issue-reporting/database"
+	"issue-reporting/incidents"
+	"issue-reporting/utils"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// maxMessage is the largest message accepted. Longer lines over TCP
+	// are dropped, longer UDP packets cut.
+	maxMessage = 64 * 1024
+	// messages are stored in batches of up to batchSize, at least every
+	// flushInterval
+	batchSize     = 500
+	flushInterval = time.Second
+	maxTitle      = 200
+)
+
+// errTooLong is returned for a newline framed message over maxMessage,
+// which is skipped without ending the connection
+var errTooLong = fmt.Errorf("message longer than %d bytes", maxMessage)
+
+// Start listens for syslog on SYSLOG_ADDR over UDP and TCP and stores the
+// messages as logs of the team SYSLOG_TEAM_ID. It does nothing unless both
+// are set.
+func Start() {
+	addr := os.Getenv("SYSLOG_ADDR")
+	teamId := os.Getenv("SYSLOG_TEAM_ID")
+	if addr == "" || teamId == "" {
+		return
+	}
+
+	var team auth.Team
+	if err := database.FindOne("teams", bson.M{"teamId": teamId}).Decode(&team); err != nil {
+		log.Printf("Error starting syslog receiver: team %s: %v", teamId, err)
+		return
+	}
+
+	s := &server{team: team}
+	go s.flushEvery(flushInterval)
+
+	packets, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Printf("Error starting syslog receiver on udp %s: %v", addr, err)
+	} else {
+		go s.serveUDP(packets)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("Error starting syslog receiver on tcp %s: %v", addr, err)
+	} else {
+		go s.serveTCP(listener)
+	}
+
+	log.Printf("Syslog receiver listening on %s for team %s", addr, teamId)
+}
+
+type server struct {
+	team    auth.Team
+	mu      sync.Mutex
+	pending []incidents.Log
+}
+
+func (s *server) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, maxMessage)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("Error reading syslog packet: %v", err)
+			return
+		}
+		s.receive(string(buf[:n]))
+	}
+}
+
+func (s *server) serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("Error accepting syslog connection: %v", err)
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn reads messages framed by octet counting or by newlines, see
+// RFC 6587
+func (s *server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReaderSize(conn, maxMessage)
+	for {
+		raw, err := readFrame(reader)
+		if err == errTooLong {
+			log.Printf("Dropped syslog message: %v", err)
+			continue
+		}
+		if raw != "" {
+			s.receive(raw)
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Error reading syslog connection: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// readFrame never buffers more than the reader's size, maxMessage for
+// connections, so a sender cannot make it hold an endless line
+func readFrame(reader *bufio.Reader) (string, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+		length, err := reader.ReadSlice(' ')
+		if err != nil {
+			if err == bufio.ErrBufferFull {
+				return "", errors.New("invalid octet count")
+			}
+			return "", err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(string(length)))
+		if err != nil || n > maxMessage {
+			return "", errors.New("invalid octet count")
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			return "", err
+		}
+		return string(frame), nil
+	}
+
+	line, err := reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		for err == bufio.ErrBufferFull {
+			_, err = reader.ReadSlice('\n')
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", errTooLong
+	}
+	return strings.TrimRight(string(line), "\r\n"), err
+}
+
+func (s *server) receive(raw string) {
+	message, err := Parse(raw, time.Now())
+	if err != nil {
+		log.Printf("Error parsing syslog message: %v", err)
+		return
+	}
+
+	logx, err := ToLog(message)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	s.mu.Lock()
+	s.pending = append(s.pending, logx)
+	full := len(s.pending) >= batchSize
+	s.mu.Unlock()
+
+	if full {
+		s.flush()
+	}
+}
+
+func (s *server) flushEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.flush()
+	}
+}
+
+func (s *server) flush() {
+	s.mu.Lock()
+	logs := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	if len(logs) == 0 {
+		return
+	}
+	if err := api.StoreLogs(s.team, logs); err != nil {
+		log.Printf("Error storing %d syslog messages: %v", len(logs), err)
+	}
+}
+
+// ToLog maps a syslog message onto a log. App is the app name (the tag in
+// BSD syslog) and Slug the severity keyword, so log rules can match e.g.
+// sshd messages at err.
+func ToLog(message Message) (incidents.Log, error) {
+	code, err := utils.GenerateRandomCode(6)
+	if err != nil {
+		return incidents.Log{}, err
+	}
+
+	title, _, _ := strings.Cut(message.Text, "\n")
+	title = utils.Truncate(title, maxTitle)
+	if message.Hostname != "" {
+		title = fmt.Sprintf("%s: %s", message.Hostname, title)
+	}
+
+	metadata, err := json.Marshal(message)
+	if err != nil {
+		return incidents.Log{}, err
+	}
+
+	return incidents.Log{
+		Id:          code,
+		Title:       title,
+		Description: message.Text,
+		CreatedAt:   message.Timestamp,
+		Metadata:    string(metadata),
+		Slug:        message.SeverityName(),
+		App:         message.AppName,
+	}, nil
+}
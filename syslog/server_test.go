@@ -0,0 +1,63 @@
+package syslog
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestReadFrame(t *testing.T) {
+	long := strings.Repeat("x", maxMessage+10)
+	input := "<13>first\r\n" +
+		"11 <13>counted" +
+		"<13>" + long + "\n" +
+		"<13>after the long one\n" +
+		"<13>no trailing newline"
+	reader := bufio.NewReaderSize(strings.NewReader(input), maxMessage)
+
+	want := []struct {
+		frame string
+		err   error
+	}{
+		{"<13>first", nil},
+		{"<13>counted", nil},
+		{"", errTooLong},
+		{"<13>after the long one", nil},
+		{"<13>no trailing newline", io.EOF},
+	}
+	for i, w := range want {
+		frame, err := readFrame(reader)
+		if frame != w.frame || err != w.err {
+			t.Errorf("frame %d = %q, %v; want %q, %v", i, frame, err, w.frame, w.err)
+		}
+	}
+}
+
+func TestReadFrameInvalidOctetCount(t *testing.T) {
+	for _, input := range []string{"99999999 <13>too long", strings.Repeat("9", maxMessage+1)} {
+		reader := bufio.NewReaderSize(strings.NewReader(input), maxMessage)
+		if _, err := readFrame(reader); err == nil || err == io.EOF {
+			t.Errorf("readFrame(%.20q...) error = %v, want invalid octet count", input, err)
+		}
+	}
+}
+
+func TestToLogTitle(t *testing.T) {
+	// a multi-byte character straddles maxTitle
+	text := strings.Repeat("a", maxTitle-1) + "é tail\nsecond line"
+	logx, err := ToLog(Message{Hostname: "web-1", Text: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utf8.ValidString(logx.Title) {
+		t.Errorf("title %q is not valid UTF-8", logx.Title)
+	}
+	if want := "web-1: " + strings.Repeat("a", maxTitle-1); logx.Title != want {
+		t.Errorf("title is %d bytes, want %d", len(logx.Title), len(want))
+	}
+	if logx.Description != text {
+		t.Error("description does not keep the whole message")
+	}
+}
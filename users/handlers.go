@@ -206,3 +206,21 @@ func DeleteUser(c *fiber.Ctx) error {
 		"user":    &user,
 	})
 }
+
+// WithRoles returns every member of the team holding at least one of the given roles
+func WithRoles(teamId string, roles ...auth.Role) ([]auth.User, error) {
+	ctx := context.Background()
+	filter := bson.M{"teamId": teamId, "role": bson.M{"$in": roles}}
+	cursor, err := database.Find("users", filter)
+	if err != nil {
+		return nil, fmt.Errorf("error finding users: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var users []auth.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("error decoding users: %v", err)
+	}
+
+	return users, nil
+}
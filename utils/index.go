@@ -3,6 +3,7 @@ package utils
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"unicode/utf8"
 )
 
 func GenerateRandomCode(length int) (string, error) {
@@ -20,3 +21,14 @@ func GenerateRandomCode(length int) (string, error) {
 	code := hex.EncodeToString(randomBytes)[:length]
 	return code, nil
 }
+
+// Truncate shortens s to at most max bytes without splitting a character
+func Truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}